@@ -0,0 +1,224 @@
+package gobancrop
+
+import (
+	"image"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Stone is what occupies an intersection.
+type Stone uint8
+
+const (
+	Empty Stone = iota
+	Black
+	White
+)
+
+func (s Stone) String() string {
+	switch s {
+	case Black:
+		return "black"
+	case White:
+		return "white"
+	}
+	return "empty"
+}
+
+// Board is a position recognised from an image.
+type Board struct {
+	Size       int
+	Stones     []Stone   // row-major, Size×Size, row 0 at the top
+	Confidence []float64 // for each intersection, in [0,1]
+}
+
+// At returns the stone at column col and row row, both zero-based.
+func (b *Board) At(col, row int) Stone {
+	return b.Stones[row*b.Size+col]
+}
+
+// String draws the board with X for black, O for white and . for empty intersections.
+func (b *Board) String() string {
+	var sb strings.Builder
+	for row := 0; row < b.Size; row++ {
+		for col := 0; col < b.Size; col++ {
+			sb.WriteByte(".XO"[b.At(col, row)])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// ReadBoard recognises the stones on the size×size grid whose outer lines run along quad.
+func ReadBoard(img *image.NRGBA, quad Quadrilateral, size int) (*Board, error) {
+	if size < 2 {
+		return nil, ErrInvalidBoardSize
+	}
+	// Warp with half a cell of margin, so that stones on the edge are seen whole
+	const cell = 24
+	pad := 0.5 / float64(size-1)
+	padded := Quadrilateral{
+		interpQuadPoint(quad, -pad, -pad),
+		interpQuadPoint(quad, 1+pad, -pad),
+		interpQuadPoint(quad, 1+pad, 1+pad),
+		interpQuadPoint(quad, -pad, 1+pad),
+	}
+	side := cell * size
+	warped, err := CropAndCorrect(img, padded, side)
+	if err != nil {
+		return nil, err
+	}
+	step := float64(side-1) / float64(size)
+	pos := func(c, r float64) Point { return Point{(c + 0.5) * step, (r + 0.5) * step} }
+	return readStones(warped, size, pos, step), nil
+}
+
+// ReadCroppedBoard recognises the stones in the output of CropAndCorrect for a
+// refined quad, where the outer lines of the size×size grid run along the image edges.
+func ReadCroppedBoard(crop *image.NRGBA, size int) (*Board, error) {
+	if size < 2 {
+		return nil, ErrInvalidBoardSize
+	}
+	b := crop.Bounds()
+	sx, sy := float64(b.Dx()-1)/float64(size-1), float64(b.Dy()-1)/float64(size-1)
+	pos := func(c, r float64) Point { return Point{float64(b.Min.X) + c*sx, float64(b.Min.Y) + r*sy} }
+	return readStones(crop, size, pos, math.Min(sx, sy)), nil
+}
+
+// readStones classifies each intersection by its brightness and saturation relative
+// to the wood in the surrounding cells, which adapts to uneven lighting. The
+// differences are clustered into empty, black and white with k-means.
+func readStones(img *image.NRGBA, size int, pos func(c, r float64) Point, pitch float64) *Board {
+	// Stones are sampled on the diagonals, away from the grid lines and star points
+	var diag []Point
+	for _, f := range []float64{0.18, 0.26, 0.34} {
+		d := f * pitch
+		diag = append(diag, Point{-d, -d}, Point{d, -d}, Point{-d, d}, Point{d, d})
+	}
+	// Cell centres are never covered by stones, so they show the bare wood
+	centre := []Point{{0, 0}, {-0.1 * pitch, 0}, {0.1 * pitch, 0}, {0, -0.1 * pitch}, {0, 0.1 * pitch}}
+	cells := size - 1
+	woodV := make([]float64, cells*cells)
+	woodS := make([]float64, cells*cells)
+	for r := 0; r < cells; r++ {
+		for c := 0; c < cells; c++ {
+			woodV[r*cells+c], woodS[r*cells+c] = sampleVS(img, pos(float64(c)+0.5, float64(r)+0.5), centre)
+		}
+	}
+
+	n := size * size
+	feats := make([][2]float64, n)
+	for r := 0; r < size; r++ {
+		for c := 0; c < size; c++ {
+			v, s := sampleVS(img, pos(float64(c), float64(r)), diag)
+			var wv, ws []float64
+			for _, cr := range [4][2]int{{r - 1, c - 1}, {r - 1, c}, {r, c - 1}, {r, c}} {
+				if cr[0] >= 0 && cr[1] >= 0 && cr[0] < cells && cr[1] < cells {
+					wv = append(wv, woodV[cr[0]*cells+cr[1]])
+					ws = append(ws, woodS[cr[0]*cells+cr[1]])
+				}
+			}
+			feats[r*size+c] = [2]float64{v - median(wv), s - median(ws)}
+		}
+	}
+
+	// Seed empty at the wood itself, black at the darkest and white at the least saturated bright point
+	centres := [3][2]float64{}
+	centres[Black] = feats[0]
+	centres[White] = feats[0]
+	for _, f := range feats {
+		if f[0] < centres[Black][0] {
+			centres[Black] = f
+		}
+		if f[0]-f[1] > centres[White][0]-centres[White][1] {
+			centres[White] = f
+		}
+	}
+	dist := func(a, b [2]float64) float64 { return math.Hypot(a[0]-b[0], a[1]-b[1]) }
+	labels := make([]Stone, n)
+	for iter := 0; iter < 10; iter++ {
+		var sum [3][2]float64
+		var cnt [3]int
+		for i, f := range feats {
+			best := Empty
+			for k := Black; k <= White; k++ {
+				if dist(f, centres[k]) < dist(f, centres[best]) {
+					best = k
+				}
+			}
+			labels[i] = best
+			sum[best][0] += f[0]
+			sum[best][1] += f[1]
+			cnt[best]++
+		}
+		for k := range centres {
+			if cnt[k] > 0 {
+				centres[k] = [2]float64{sum[k][0] / float64(cnt[k]), sum[k][1] / float64(cnt[k])}
+			}
+		}
+	}
+	// A board without black or white stones still has three clusters, drop those
+	// that are too close to the wood to be stones
+	const minContrast = 0.15
+	present := [3]bool{true, dist(centres[Black], centres[Empty]) > minContrast && centres[Black][0] < centres[Empty][0],
+		dist(centres[White], centres[Empty]) > minContrast}
+
+	b := &Board{Size: size, Stones: make([]Stone, n), Confidence: make([]float64, n)}
+	for i, f := range feats {
+		var d [3]float64
+		for k := range centres {
+			d[k] = math.Inf(1)
+			if present[k] {
+				d[k] = dist(f, centres[k])
+			}
+		}
+		best, bestD, secondD := Empty, math.Inf(1), math.Inf(1)
+		for k := Empty; k <= White; k++ {
+			if d[k] < bestD {
+				best, bestD, secondD = k, d[k], bestD
+			} else if d[k] < secondD {
+				secondD = d[k]
+			}
+		}
+		b.Stones[i] = best
+		switch {
+		case math.IsInf(secondD, 1):
+			b.Confidence[i] = 1
+		case bestD+secondD > 0:
+			b.Confidence[i] = (secondD - bestD) / (secondD + bestD)
+		}
+	}
+	return b
+}
+
+// sampleVS returns the mean HSV value and saturation of img at p plus each offset,
+// skipping points outside the image.
+func sampleVS(img *image.NRGBA, p Point, offsets []Point) (v, s float64) {
+	n := 0
+	b := img.Bounds()
+	for _, o := range offsets {
+		x, y := p.X+o.X, p.Y+o.Y
+		if x < float64(b.Min.X) || y < float64(b.Min.Y) || x > float64(b.Max.X-1) || y > float64(b.Max.Y-1) {
+			continue
+		}
+		r, g, bl, _ := sampleBilinear(img, Point{x, y}).RGBA()
+		_, ss, vv := rgbToHSV(float64(r)/65535, float64(g)/65535, float64(bl)/65535)
+		v += vv
+		s += ss
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return v / float64(n), s / float64(n)
+}
+
+func median(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	vs := append([]float64(nil), vals...)
+	sort.Float64s(vs)
+	return vs[len(vs)/2]
+}
@@ -0,0 +1,96 @@
+package gobancrop
+
+import (
+	"errors"
+	"image/color"
+	"testing"
+)
+
+func TestReadBoard(t *testing.T) {
+	const size, cell, margin = 9, 30, 25
+	empty := syntheticBoard(size, cell, margin)
+	quad := syntheticQuad(size, cell, margin)
+	b, err := ReadBoard(empty, quad, size)
+	if err != nil {
+		t.Fatalf("ReadBoard: %v", err)
+	}
+	for i, s := range b.Stones {
+		if s != Empty {
+			t.Fatalf("empty board has a %v stone at %d:\n%v", s, i, b)
+		}
+	}
+
+	img := syntheticBoard(size, cell, margin)
+	colors := map[Stone]color.NRGBA{Black: {20, 20, 25, 255}, White: {240, 240, 235, 255}}
+	want := make([]Stone, size*size)
+	for i := range want {
+		switch {
+		case i%7 == 0:
+			want[i] = Black
+		case i%5 == 1:
+			want[i] = White
+		}
+		if want[i] != Empty {
+			drawStone(img, margin+i%size*cell, margin+i/size*cell, cell/2-1, colors[want[i]])
+		}
+	}
+	b, err = ReadBoard(img, quad, size)
+	if err != nil {
+		t.Fatalf("ReadBoard: %v", err)
+	}
+	for i, s := range b.Stones {
+		if s != want[i] {
+			t.Errorf("intersection %d: got %v, want %v (confidence %.2f)", i, s, want[i], b.Confidence[i])
+		}
+	}
+}
+
+func TestReadCroppedBoard(t *testing.T) {
+	const size, cell, margin = 9, 30, 25
+	img := syntheticBoard(size, cell, margin)
+	colors := map[Stone]color.NRGBA{Black: {20, 20, 25, 255}, White: {240, 240, 235, 255}}
+	want := make([]Stone, size*size)
+	for i := range want {
+		switch {
+		case i%6 == 0:
+			want[i] = Black
+		case i%4 == 1:
+			want[i] = White
+		}
+		if want[i] != Empty {
+			drawStone(img, margin+i%size*cell, margin+i/size*cell, cell/2-1, colors[want[i]])
+		}
+	}
+	crop, err := CropAndCorrect(img, syntheticQuad(size, cell, margin), 320)
+	if err != nil {
+		t.Fatalf("CropAndCorrect: %v", err)
+	}
+	b, err := ReadCroppedBoard(crop, size)
+	if err != nil {
+		t.Fatalf("ReadCroppedBoard: %v", err)
+	}
+	for i, s := range b.Stones {
+		if s != want[i] {
+			t.Errorf("intersection %d: got %v, want %v (confidence %.2f)", i, s, want[i], b.Confidence[i])
+		}
+	}
+	if _, err := ReadCroppedBoard(crop, 1); !errors.Is(err, ErrInvalidBoardSize) {
+		t.Errorf("size 1: %v, want ErrInvalidBoardSize", err)
+	}
+}
+
+func TestWriteSGF(t *testing.T) {
+	b := &Board{Size: 3, Stones: []Stone{
+		Black, Empty, Empty,
+		Empty, White, Black,
+		Empty, Empty, White,
+	}}
+	got, err := b.SGF(SGFInfo{ToPlay: White, Source: "game[1].png", Comment: `confidence 0.93`})
+	if err != nil {
+		t.Fatalf("SGF: %v", err)
+	}
+	want := "(;GM[1]FF[4]CA[UTF-8]AP[gobancrop]SZ[3]PL[W]SO[game[1\\].png]C[confidence 0.93]AB[aa][cb]AW[bb][cc])\n"
+	if got != want {
+		t.Errorf("SGF =\n%s\nwant\n%s", got, want)
+	}
+}
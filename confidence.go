@@ -0,0 +1,146 @@
+package gobancrop
+
+import (
+	"image"
+	"math"
+)
+
+// Confidence tells how much a detected grid can be trusted. Every part is in
+// [0,1], where a clean screenshot scores close to 1, except Wood, which is NaN
+// and left out of Overall for boards without the colour of wood. Grids of the
+// wrong size tend to score below 0.7 Overall, results below that are worth a
+// human look.
+type Confidence struct {
+	Overall float64 // weighted geometric mean of the parts below
+
+	Lattice    float64 // share of the lines seen and explained, and how evenly spaced they are, low when an outer line was not seen
+	Contrast   float64 // how much darker the lines are than the wood between them
+	Squareness float64 // how close the grid is to square, a real board is slightly taller
+	Hoshi      float64 // star points where the board size says they are and not elsewhere, 0.5 when unknown
+	Wood       float64 // share of the grid area, stones and lines aside, with the colour of wood, or NaN
+}
+
+// Weights of the parts of Confidence in the Overall score.
+const (
+	latticeWeight    = 0.5
+	contrastWeight   = 0.15
+	squarenessWeight = 0.1
+	hoshiWeight      = 0.1
+	woodWeight       = 0.15
+)
+
+// newConfidence combines the measured parts into a Confidence. rowRes and
+// colRes are the residuals of the lines of quad, as in Grid.
+func newConfidence(c lineCandidate, quad Quadrilateral, rowRes, colRes []float64) Confidence {
+	var conf Confidence
+	// A lattice that leaves half the lines unexplained is as good as none
+	conf.Lattice = clamp01((c.lattice - 0.5) / 0.4)
+	last := c.size - 1
+	if outerUnseen(rowRes, 0) || outerUnseen(rowRes, last) || outerUnseen(colRes, 0) || outerUnseen(colRes, last) {
+		// An outer line that was not seen is most often a grid a line off, with
+		// every other line in place
+		conf.Lattice = math.Min(conf.Lattice, 0.2)
+	}
+	// Lines a quarter darker than the wood are as clear as they get
+	conf.Contrast = clamp01(c.contrast / 0.25)
+	conf.Squareness = clamp01((quadSquareness(quad) - 0.5) / 0.4)
+	conf.Hoshi = 0.5
+	if HoshiPoints(c.size) != nil {
+		// Stones on the star points hide them, so a missing dot only counts half,
+		// while dots away from the star points count against the grid
+		conf.Hoshi = clamp01(0.5 + 0.5*c.hoshi)
+	}
+	conf.Wood = clamp01(c.wood) // NaN stays NaN
+
+	logSum, weights := 0.0, 0.0
+	for _, p := range [][2]float64{
+		{conf.Lattice, latticeWeight},
+		{conf.Contrast, contrastWeight},
+		{conf.Squareness, squarenessWeight},
+		{conf.Hoshi, hoshiWeight},
+		{conf.Wood, woodWeight},
+	} {
+		if math.IsNaN(p[0]) {
+			continue
+		}
+		logSum += p[1] * math.Log(math.Max(p[0], 0.01))
+		weights += p[1]
+	}
+	conf.Overall = math.Exp(logSum / weights)
+	return conf
+}
+
+// lineContrast returns how much darker the fitted lines of img are than the
+// middle of the cells between them, relative to the cells. Stones are left out.
+func lineContrast(img *image.NRGBA, stones []bool, ys, xs []float64) float64 {
+	w := img.Bounds().Dx()
+	brightness := func(x, y int) (float64, bool) {
+		if x < 0 || y < 0 || x >= w || y >= img.Bounds().Dy() || stones[y*w+x] {
+			return 0, false
+		}
+		c := img.NRGBAAt(x, y)
+		return float64(int(c.R)+int(c.G)+int(c.B)) / 3, true
+	}
+	var line, cell float64
+	var nLine, nCell int
+	// Along rows and columns alike: position k across, t along the line
+	sample := func(across, along []float64, at func(a, t int) (float64, bool)) {
+		lo, hi := int(math.Round(along[0])), int(math.Round(along[len(along)-1]))
+		for k, a := range across {
+			for t := lo; t <= hi; t++ {
+				// The darkest of the pixels around the fitted line, lines are not always centred
+				darkest, ok := math.Inf(1), false
+				for o := -1; o <= 1; o++ {
+					if v, in := at(int(math.Round(a))+o, t); in && v < darkest {
+						darkest, ok = v, true
+					}
+				}
+				if ok {
+					line += darkest
+					nLine++
+				}
+				if k+1 < len(across) {
+					if v, in := at(int(math.Round((a+across[k+1])/2)), t); in {
+						cell += v
+						nCell++
+					}
+				}
+			}
+		}
+	}
+	sample(ys, xs, func(y, x int) (float64, bool) { return brightness(x, y) })
+	sample(xs, ys, func(x, y int) (float64, bool) { return brightness(x, y) })
+	if nLine == 0 || nCell == 0 || cell == 0 {
+		return 0
+	}
+	line, cell = line/float64(nLine), cell/float64(nCell)
+	return math.Max(0, (cell-line)/cell)
+}
+
+// woodCoverage returns the share of the pixels within the outer lines that have
+// the colour of wood, leaving out stones and line pixels.
+func (d *Detector) woodCoverage(img *image.NRGBA, stones, grid []bool, ys, xs []float64) float64 {
+	w := img.Bounds().Dx()
+	x0, x1 := int(math.Ceil(xs[0])), int(xs[len(xs)-1])
+	y0, y1 := int(math.Ceil(ys[0])), int(ys[len(ys)-1])
+	wood, total := 0, 0
+	for y := max(0, y0); y <= min(img.Bounds().Dy()-1, y1); y++ {
+		for x := max(0, x0); x <= min(w-1, x1); x++ {
+			if stones[y*w+x] || grid[y*w+x] {
+				continue
+			}
+			total++
+			if d.isWood(img.NRGBAAt(x, y)) {
+				wood++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(wood) / float64(total)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,82 @@
+package gobancrop
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/xyproto/carveimg"
+)
+
+func TestConfidence(t *testing.T) {
+	img := syntheticBoard(19, 24, 30)
+	side := float64(img.Bounds().Dx() - 1)
+	quad := Quadrilateral{{0, 0}, {side, 0}, {side, side}, {0, side}}
+	for _, tc := range []struct {
+		size   int
+		lo, hi float64
+	}{
+		{19, 0.85, 1},
+		{13, 0, 0.7}, // 13 of the 19 lines
+		{9, 0, 0.3},
+	} {
+		g, err := FindActualBoard(img, quad, tc.size)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", tc.size, tc.size, err)
+		}
+		c := g.Confidence
+		if c.Overall < tc.lo || c.Overall > tc.hi {
+			t.Errorf("%dx%d: confidence %+v, want Overall in [%g,%g]", tc.size, tc.size, c, tc.lo, tc.hi)
+		}
+		for _, p := range []float64{c.Lattice, c.Contrast, c.Squareness, c.Hoshi, c.Wood} {
+			if p < 0 || p > 1 {
+				t.Errorf("%dx%d: confidence %+v out of range", tc.size, tc.size, c)
+			}
+		}
+	}
+}
+
+func TestConfidenceOuterLines(t *testing.T) {
+	c := lineCandidate{size: 19, lattice: 0.95, contrast: 0.3, hoshi: 0.8, wood: 1}
+	seen := make([]float64, 19)
+	if conf := newConfidence(c, syntheticQuad(19, 20, 20), seen, seen); conf.Overall < 0.85 {
+		t.Errorf("every line seen: confidence %+v", conf)
+	}
+	// Every line but the last row in place, as in a grid a line off
+	unseen := slices.Clone(seen)
+	unseen[18] = math.NaN()
+	if conf := newConfidence(c, syntheticQuad(19, 20, 20), unseen, seen); conf.Overall > 0.7 {
+		t.Errorf("last row not seen: confidence %+v, want below 0.7", conf)
+	}
+}
+
+func TestFindCandidates(t *testing.T) {
+	img, err := carveimg.LoadImage("img/kgs_screenshot3.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quad, err := FindGoban(img)
+	if err != nil {
+		t.Fatalf("FindGoban: %v", err)
+	}
+	grids, err := FindCandidates(img, quad, 0, 5)
+	if err != nil {
+		t.Fatalf("FindCandidates: %v", err)
+	}
+	if len(grids) < 3 || len(grids) > 5 {
+		t.Fatalf("%d candidates, want 3 to 5", len(grids))
+	}
+	if grids[0].Size != 19 {
+		t.Errorf("best candidate is %dx%d", grids[0].Size, grids[0].Size)
+	}
+	for i, g := range grids {
+		if i > 0 && g.Confidence.Overall > grids[i-1].Confidence.Overall {
+			t.Errorf("candidate %d scores higher than the one before", i)
+		}
+		for _, o := range grids[:i] {
+			if o.Size == g.Size && dist(o.Quad[0], g.Quad[0]) < 1 && dist(o.Quad[2], g.Quad[2]) < 1 {
+				t.Errorf("candidate %d repeats an earlier one", i)
+			}
+		}
+	}
+}
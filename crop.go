@@ -0,0 +1,175 @@
+package gobancrop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+)
+
+// Options controls Crop. The zero value detects the board size and crops to 512×512 pixels.
+type Options struct {
+	OutputSize int  // side of the cropped image in pixels, 0 for 512
+	BoardSize  int  // lines in each direction, 0 to detect among the Detector's Sizes
+	Hint       Hint // where the board roughly is, the zero Hint searches the whole image
+}
+
+// Result is the outcome of Crop.
+type Result struct {
+	Coarse     Quadrilateral // the wood region found by FindGoban
+	Refined    Quadrilateral // the outer grid lines, or a guess from Coarse when Fallback is set
+	Fallback   bool          // the grid was not found and Refined was shrunk from Coarse
+	Grid       *Grid         // the detected grid, nil when Fallback is set
+	Image      *image.NRGBA  // the perspective corrected board
+	BoardSize  int
+	Confidence float64 // Grid.Confidence.Overall, 0 when Fallback is set
+}
+
+// Crop finds the board in img and returns it cropped and perspective corrected.
+// When the grid lines can not be found, the coarse board outline is shrunk by
+// half a cell instead. opts may be nil.
+func Crop(img *image.NRGBA, opts *Options) (*Result, error) {
+	return NewDetector().Crop(img, opts)
+}
+
+// Crop is like the package level Crop, with the parameters of d.
+func (d *Detector) Crop(img *image.NRGBA, opts *Options) (*Result, error) {
+	o, err := withDefaults(opts)
+	if err != nil {
+		return nil, err
+	}
+	coarse, err := d.FindGobanNear(img, o.Hint)
+	if errors.Is(err, ErrNoBoardRegion) && o.Hint.IsZero() {
+		// No wood, but maybe the grid lines of a painted board or a grey screenshot
+		if q, lerr := d.FindGobanLines(img); lerr == nil {
+			coarse, err = q, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.cropCoarse(img, coarse, o)
+	if err == nil && res.Fallback {
+		d.log().Warn("Crop: grid not found, shrinking the coarse quad instead", "quad", coarse)
+	}
+	return res, err
+}
+
+// withDefaults returns a copy of opts with the defaults filled in, or
+// ErrInvalidBoardSize for a board size that is neither 0 nor at least 2.
+func withDefaults(opts *Options) (Options, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.BoardSize != 0 && o.BoardSize < 2 {
+		return o, fmt.Errorf("%w: %d", ErrInvalidBoardSize, o.BoardSize)
+	}
+	if o.OutputSize == 0 {
+		o.OutputSize = 512
+	}
+	return o, nil
+}
+
+// cropCoarse finds the grid within the coarse quad and crops the board.
+func (d *Detector) cropCoarse(img *image.NRGBA, coarse Quadrilateral, o Options) (*Result, error) {
+	res := &Result{Coarse: coarse}
+	grid, err := d.FindActualBoard(img, coarse, o.BoardSize)
+	if errors.Is(err, ErrGridNotFound) {
+		d.log().Debug("Crop: grid not found", "quad", coarse, "err", err)
+		res.BoardSize = o.BoardSize
+		if res.BoardSize == 0 {
+			res.BoardSize = 19
+		}
+		res.Fallback = true
+		if res.Refined, err = ShrinkQuad(coarse, res.BoardSize); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		res.Grid = grid
+		res.BoardSize = grid.Size
+		res.Refined = grid.Quad
+		res.Confidence = grid.Confidence.Overall
+	}
+
+	res.Image, err = CropAndCorrect(img, res.Refined, o.OutputSize)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// minBoardConfidence is how much FindGobans must trust a grid to count a wood region as a board.
+const minBoardConfidence = 0.3
+
+// FindGobans finds every board in img, such as a game and its analysis board,
+// or the games on a club table. Each wood region is cropped like Crop does, but
+// only regions with a grid count as boards. The boards are ordered in rows from
+// top to bottom and from left to right within a row. opts may be nil, its Hint is
+// not used. Regions that cannot be cropped are skipped, and an image without
+// boards gives ErrNoBoardRegion.
+func FindGobans(img *image.NRGBA, opts *Options) ([]*Result, error) {
+	return NewDetector().FindGobans(img, opts)
+}
+
+// FindGobans is like the package level FindGobans, with the parameters of d.
+func (d *Detector) FindGobans(img *image.NRGBA, opts *Options) ([]*Result, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	o, err := withDefaults(opts)
+	if err != nil {
+		return nil, err
+	}
+	var boards []*Result
+	for _, r := range d.WoodRegions(img) {
+		if len(r.hull) < 4 {
+			continue
+		}
+		res, err := d.cropCoarse(img, r.Quad, o)
+		if err != nil {
+			d.log().Debug("FindGobans: cannot crop region", "region", r.Bounds, "err", err)
+			continue
+		}
+		if res.Fallback || res.Confidence < minBoardConfidence {
+			d.log().Debug("FindGobans: not a board", "region", r.Bounds, "confidence", res.Confidence)
+			continue
+		}
+		boards = append(boards, res)
+	}
+	if len(boards) == 0 {
+		return nil, ErrNoBoardRegion
+	}
+	sortReadingOrder(boards)
+	return boards, nil
+}
+
+// sortReadingOrder sorts boards into rows, where a board belongs to a row when its
+// centre is level with the first board of the row, and each row from left to right.
+func sortReadingOrder(boards []*Result) {
+	span := func(r *Result) (lo, hi float64) {
+		b := quadBounds(r.Refined)
+		return float64(b.Min.Y), float64(b.Max.Y)
+	}
+	sort.SliceStable(boards, func(i, j int) bool { return quadCentre(boards[i].Refined).Y < quadCentre(boards[j].Refined).Y })
+	row := make([]int, len(boards))
+	for i, top := 1, 0; i < len(boards); i++ {
+		lo, hi := span(boards[top])
+		if c := quadCentre(boards[i].Refined).Y; c < lo || c > hi {
+			top = i
+		}
+		row[i] = top
+	}
+	order := make(map[*Result]int, len(boards))
+	for i, b := range boards {
+		order[b] = row[i]
+	}
+	sort.SliceStable(boards, func(i, j int) bool {
+		if ri, rj := order[boards[i]], order[boards[j]]; ri != rj {
+			return ri < rj
+		}
+		return quadCentre(boards[i].Refined).X < quadCentre(boards[j].Refined).X
+	})
+}
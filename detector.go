@@ -0,0 +1,137 @@
+package gobancrop
+
+import (
+	"fmt"
+	"image/color"
+	"log/slog"
+)
+
+// Detector holds the parameters used to find and crop a board. The package level
+// functions use the defaults from NewDetector, change a copy to tune the detection
+// for a particular camera or client.
+type Detector struct {
+	// Hue range in degrees, and minimum saturation and value in [0,1], of the wood.
+	// Defaults: 10-55°, 0.15 and 0.2.
+	WoodHueMin, WoodHueMax float64
+	WoodMinSaturation      float64
+	WoodMinValue           float64
+
+	// WarpSize is the side in pixels of the square the coarse board is warped
+	// to before line detection. Default: 512.
+	WarpSize int
+
+	// PaletteColors is how many colours palgen.Reduce maps the warped board to
+	// before line detection, 0 skips the reduction. Stones are always masked in
+	// the real colours. Reduction makes the lines harder to tell from the wood
+	// on the images in img/, so the default is 0.
+	PaletteColors int
+
+	// GridHue is the hue in degrees that grid detection considers wood, pixels
+	// further than GridHueTolerance from it count as lines. Defaults: 35° and 25°.
+	GridHue, GridHueTolerance float64
+
+	// LineFracs are the shares of a row or column that must be line pixels for it
+	// to be part of a line, and LineWidths the widest runs of such rows that still
+	// count as a line. Line detection tries every combination.
+	// Defaults: 0.5 down to 0.0075, and 8 down to 3 pixels.
+	LineFracs  []float64
+	LineWidths []int
+
+	// MaxLineWidth is the widest a grid line can be in the warped board, in
+	// pixels. Anything thicker and darker or lighter than the wood may be a stone.
+	// Default: 5.
+	MaxLineWidth int
+
+	// Sizes are the board sizes to choose from when the size is detected.
+	// Default: StandardSizes.
+	Sizes []int
+
+	// Logger receives debug records of the intermediate results, such as
+	// thresholds, line counts and quads. Nil, the default, logs nothing.
+	Logger *slog.Logger
+
+	// Stages receives the intermediate images, see StageSink. Nil, the default,
+	// skips building them.
+	Stages StageSink
+}
+
+// StandardSizes are the board sizes chosen from by default when the size is detected.
+var StandardSizes = []int{9, 13, 19}
+
+// NewDetector returns a Detector with the default parameters.
+func NewDetector() *Detector {
+	return &Detector{
+		WoodHueMin:        10,
+		WoodHueMax:        55,
+		WoodMinSaturation: 0.15,
+		WoodMinValue:      0.2,
+		WarpSize:          512,
+		PaletteColors:     0,
+		GridHue:           35,
+		GridHueTolerance:  25,
+		LineFracs:         []float64{0.5, 0.4, 0.3, 0.2, 0.1, 0.05, 0.03, 0.025, 0.02, 0.015, 0.01, 0.0075},
+		LineWidths:        []int{8, 7, 6, 5, 4, 3},
+		MaxLineWidth:      5,
+		Sizes:             append([]int(nil), StandardSizes...),
+	}
+}
+
+// Validate checks that the parameters are usable. The error wraps ErrInvalidParameters.
+func (d *Detector) Validate() error {
+	switch {
+	case d.WoodHueMin < 0 || d.WoodHueMax > 360 || d.WoodHueMin > d.WoodHueMax:
+		return fmt.Errorf("%w: wood hue range %g-%g", ErrInvalidParameters, d.WoodHueMin, d.WoodHueMax)
+	case d.WoodMinSaturation < 0 || d.WoodMinSaturation > 1:
+		return fmt.Errorf("%w: wood saturation %g", ErrInvalidParameters, d.WoodMinSaturation)
+	case d.WoodMinValue < 0 || d.WoodMinValue > 1:
+		return fmt.Errorf("%w: wood value %g", ErrInvalidParameters, d.WoodMinValue)
+	case d.WarpSize < 64:
+		return fmt.Errorf("%w: warp size %d is too small", ErrInvalidParameters, d.WarpSize)
+	case d.PaletteColors < 0 || d.PaletteColors == 1:
+		return fmt.Errorf("%w: number of palette colors %d", ErrInvalidParameters, d.PaletteColors)
+	case d.GridHue < 0 || d.GridHue > 360:
+		return fmt.Errorf("%w: grid hue %g", ErrInvalidParameters, d.GridHue)
+	case d.GridHueTolerance <= 0 || d.GridHueTolerance > 180:
+		return fmt.Errorf("%w: grid hue tolerance %g", ErrInvalidParameters, d.GridHueTolerance)
+	case len(d.LineFracs) == 0 || len(d.LineWidths) == 0:
+		return fmt.Errorf("%w: no line fractions or widths to try", ErrInvalidParameters)
+	case d.MaxLineWidth < 1:
+		return fmt.Errorf("%w: max line width %d", ErrInvalidParameters, d.MaxLineWidth)
+	case len(d.Sizes) == 0:
+		return fmt.Errorf("%w: no board sizes to choose from", ErrInvalidParameters)
+	}
+	for _, f := range d.LineFracs {
+		if f <= 0 || f > 1 {
+			return fmt.Errorf("%w: line fraction %g", ErrInvalidParameters, f)
+		}
+	}
+	for _, w := range d.LineWidths {
+		if w < 1 {
+			return fmt.Errorf("%w: line width %d", ErrInvalidParameters, w)
+		}
+	}
+	for _, s := range d.Sizes {
+		if s < 2 {
+			return fmt.Errorf("%w: board size %d", ErrInvalidParameters, s)
+		}
+	}
+	return nil
+}
+
+// log returns the logger of d, which discards everything when none is set.
+func (d *Detector) log() *slog.Logger {
+	if d.Logger == nil {
+		return discardLogger
+	}
+	return d.Logger
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// isWood reports if c has the colour of the wood of a board.
+func (d *Detector) isWood(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	rf, gf, bf := float64(r)/65535, float64(g)/65535, float64(b)/65535
+	h, s, v := rgbToHSV(rf, gf, bf)
+	return h >= d.WoodHueMin && h <= d.WoodHueMax && s >= d.WoodMinSaturation && v >= d.WoodMinValue
+}
@@ -0,0 +1,138 @@
+package gobancrop
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xyproto/carveimg"
+)
+
+func TestDetectorValidate(t *testing.T) {
+	if err := NewDetector().Validate(); err != nil {
+		t.Fatalf("default detector: %v", err)
+	}
+	for name, change := range map[string]func(d *Detector){
+		"hue range":  func(d *Detector) { d.WoodHueMin, d.WoodHueMax = 60, 20 },
+		"warp size":  func(d *Detector) { d.WarpSize = 0 },
+		"palette":    func(d *Detector) { d.PaletteColors = -1 },
+		"fracs":      func(d *Detector) { d.LineFracs = []float64{0.5, 2} },
+		"line width": func(d *Detector) { d.MaxLineWidth = 0 },
+		"sizes":      func(d *Detector) { d.Sizes = nil },
+	} {
+		d := NewDetector()
+		change(d)
+		if d.Validate() == nil {
+			t.Errorf("%s: invalid detector accepted", name)
+		}
+	}
+}
+
+func TestDetectorSizes(t *testing.T) {
+	const size, cell, margin = 13, 30, 40
+	img := syntheticBoard(size, cell, margin)
+	d := NewDetector()
+	d.Sizes = []int{size}
+	side := float64(img.Bounds().Dx() - 1)
+	grid, err := d.FindActualBoard(img, Quadrilateral{{0, 0}, {side, 0}, {side, side}, {0, side}}, 0)
+	if err != nil {
+		t.Fatalf("FindActualBoard: %v", err)
+	}
+	if grid.Size != size || len(grid.Candidates) != 1 {
+		t.Errorf("size = %d with %d candidates, want only %d", grid.Size, len(grid.Candidates), size)
+	}
+}
+
+func TestDetectorLogger(t *testing.T) {
+	const size, cell, margin = 9, 40, 40
+	img := syntheticBoard(size, cell, margin)
+	side := float64(img.Bounds().Dx() - 1)
+	quad := Quadrilateral{{0, 0}, {side, 0}, {side, side}, {0, side}}
+
+	// Silent by default
+	var global bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&global)
+	if _, err := FindActualBoard(img, quad, size); err != nil {
+		t.Fatalf("FindActualBoard: %v", err)
+	}
+	if global.Len() > 0 {
+		t.Errorf("default detector logged %q", global.String())
+	}
+
+	var buf bytes.Buffer
+	d := NewDetector()
+	d.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if _, err := d.FindActualBoard(img, quad, size); err != nil {
+		t.Fatalf("FindActualBoard: %v", err)
+	}
+	for _, field := range []string{"darkThreshold=", "rows=9", "cols=9", "size=9"} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("log is missing %s: %s", field, buf.String())
+		}
+	}
+}
+
+func TestDetectorStages(t *testing.T) {
+	img, err := carveimg.LoadImage("img/kgs_screenshot1.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]image.Rectangle)
+	d := NewDetector()
+	d.Stages = func(name string, img image.Image) { got[name] = img.Bounds() }
+	if _, err := d.Crop(img, nil); err != nil {
+		t.Fatalf("Crop: %v", err)
+	}
+	warp := image.Rect(0, 0, d.WarpSize, d.WarpSize)
+	for name, want := range map[string]image.Rectangle{
+		"wood-raw":     image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()),
+		"wood":         image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()),
+		"warped":       warp,
+		"grid-pixels":  warp,
+		"stones":       warp,
+		"profile-rows": image.Rect(0, 0, 128, d.WarpSize),
+		"profile-cols": image.Rect(0, 0, d.WarpSize, 128),
+	} {
+		if b, ok := got[name]; !ok || b != want {
+			t.Errorf("stage %s: bounds %v (seen %v), want %v", name, b, ok, want)
+		}
+	}
+	if _, ok := got["palette"]; ok {
+		t.Error("palette stage without palette reduction")
+	}
+
+	dir := t.TempDir()
+	d.Stages, err = DirSink(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.FindGoban(img); err != nil {
+		t.Fatalf("FindGoban: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.png")); len(files) != 2 {
+		t.Errorf("DirSink wrote %v, want the two wood masks", files)
+	}
+
+	// A stage that cannot be written is logged, and the detection goes on
+	var buf bytes.Buffer
+	d.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	d.Stages, err = d.DirSink(filepath.Join(dir, "gone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.FindGoban(img); err != nil {
+		t.Fatalf("FindGoban without the stage directory: %v", err)
+	}
+	if !strings.Contains(buf.String(), "cannot write stage") {
+		t.Errorf("write error not logged: %q", buf.String())
+	}
+}
@@ -0,0 +1,155 @@
+package gobancrop
+
+import (
+	"image"
+	"math"
+)
+
+// minEdge is the weakest Sobel response that counts as an edge.
+const minEdge = 40
+
+// EdgeMap is the brightness gradient of an image and the edges found in it.
+// The slices run row by row over Rect, so pixel (x, y) is at index
+// (y-Rect.Min.Y)*Rect.Dx() + x-Rect.Min.X.
+type EdgeMap struct {
+	Rect        image.Rectangle
+	Magnitude   []float64 // Sobel gradient magnitude
+	Orientation []float64 // direction of increasing brightness, in radians
+	Edges       []bool    // thinned edges, one pixel wide, nil from Sobel
+}
+
+// At returns the gradient and whether (x, y) is an edge pixel. Points outside
+// Rect have no gradient.
+func (e *EdgeMap) At(x, y int) (magnitude, orientation float64, edge bool) {
+	if !(image.Point{x, y}).In(e.Rect) {
+		return 0, 0, false
+	}
+	i := (y-e.Rect.Min.Y)*e.Rect.Dx() + x - e.Rect.Min.X
+	return e.Magnitude[i], e.Orientation[i], e.Edges != nil && e.Edges[i]
+}
+
+// EdgeImage renders the edges as white on black, or the gradient magnitude if
+// the map has no edges.
+func (e *EdgeMap) EdgeImage() *image.Gray {
+	w, h := e.Rect.Dx(), e.Rect.Dy()
+	if e.Edges != nil {
+		img := boolImage(e.Edges, w, h)
+		img.Rect = e.Rect
+		return img
+	}
+	img := image.NewGray(e.Rect)
+	for i, m := range e.Magnitude {
+		img.Pix[i] = uint8(math.Min(255, m/4))
+	}
+	return img
+}
+
+// Sobel returns the gradient of the brightness of img, without edges.
+func Sobel(img *image.NRGBA) *EdgeMap {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+y):]
+			lum[y*w+x] = (float64(p[0]) + float64(p[1]) + float64(p[2])) / 3
+		}
+	}
+	at := func(x, y int) float64 {
+		return lum[clampInt(y, 0, h-1)*w+clampInt(x, 0, w-1)]
+	}
+	e := &EdgeMap{Rect: b, Magnitude: make([]float64, w*h), Orientation: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			e.Magnitude[y*w+x], e.Orientation[y*w+x] = math.Hypot(gx, gy), math.Atan2(gy, gx)
+		}
+	}
+	return e
+}
+
+// Canny returns the gradient of img with its edges: the pixels that are the
+// strongest across the edge, with a magnitude of at least high or connected to
+// such a pixel through pixels of at least low. The 3×3 Sobel kernel is the only
+// smoothing, so noisy images may want blurring first.
+func Canny(img *image.NRGBA, low, high float64) *EdgeMap {
+	return canny(Sobel(img), low, high)
+}
+
+// canny adds the edges to the gradient e and returns it.
+func canny(e *EdgeMap, low, high float64) *EdgeMap {
+	w, h := e.Rect.Dx(), e.Rect.Dy()
+	thin := thinEdges(e.Magnitude, e.Orientation, w, h, low)
+	e.Edges = make([]bool, w*h)
+	var stack []int
+	for i, t := range thin {
+		if t && e.Magnitude[i] >= high {
+			e.Edges[i] = true
+			stack = append(stack, i)
+		}
+	}
+	// Follow the weaker pixels out from the strong ones
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%w, i/w
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+				if j := ny*w + nx; thin[j] && !e.Edges[j] {
+					e.Edges[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	return e
+}
+
+// DetectEdges is Canny with thresholds from the image itself.
+func DetectEdges(img *image.NRGBA) *EdgeMap {
+	return NewDetector().DetectEdges(img)
+}
+
+// DetectEdges is like the package level DetectEdges, passing the edges to the
+// stage sink of d.
+func (d *Detector) DetectEdges(img *image.NRGBA) *EdgeMap {
+	e := Sobel(img)
+	high := edgeThreshold(e.Magnitude)
+	canny(e, high/2, high)
+	d.stage("edges", func() image.Image { return e.EdgeImage() })
+	return e
+}
+
+// edgeThreshold returns the gradient magnitude that counts as an edge: well above
+// the mean, so texture and noise do not, and never below minEdge.
+func edgeThreshold(mag []float64) float64 {
+	sum := 0.0
+	for _, m := range mag {
+		sum += m
+	}
+	return math.Max(minEdge, 3*sum/float64(max(1, len(mag))))
+}
+
+// thinEdges keeps the pixels with a gradient magnitude of at least thr that are
+// the strongest across the edge, so every edge is one pixel wide.
+func thinEdges(mag, dir []float64, w, h int, thr float64) []bool {
+	edges := make([]bool, w*h)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			if mag[i] < thr {
+				continue
+			}
+			dx, dy := int(math.Round(math.Cos(dir[i]))), int(math.Round(math.Sin(dir[i])))
+			if mag[i] >= mag[i+dy*w+dx] && mag[i] > mag[i-dy*w-dx] {
+				edges[i] = true
+			}
+		}
+	}
+	return edges
+}
@@ -0,0 +1,67 @@
+package gobancrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestDetectEdges(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{30, 30, 30, 255}
+			if x >= 16 && x < 48 && y >= 12 && y < 36 {
+				c = color.NRGBA{200, 200, 200, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	// Offset bounds, the map is in image coordinates
+	sub := img.SubImage(image.Rect(4, 4, 60, 44)).(*image.NRGBA)
+	e := DetectEdges(sub)
+	if e.Rect != sub.Bounds() {
+		t.Fatalf("rect %v, want %v", e.Rect, sub.Bounds())
+	}
+
+	// Brightness increases into the square
+	for _, c := range []struct {
+		x, y   int
+		orient float64
+	}{{16, 24, 0}, {47, 24, math.Pi}, {32, 12, math.Pi / 2}, {32, 35, -math.Pi / 2}} {
+		mag, orient, _ := e.At(c.x, c.y)
+		if mag < minEdge || angleDelta(orient, c.orient) > 0.01 || math.Cos(orient-c.orient) < 0 {
+			t.Errorf("(%d,%d): magnitude %.0f orientation %.2f, want %.2f", c.x, c.y, mag, orient, c.orient)
+		}
+	}
+
+	// Every row through the middle of the square crosses exactly two edge pixels
+	for y := 16; y < 32; y++ {
+		var xs []int
+		for x := sub.Rect.Min.X; x < sub.Rect.Max.X; x++ {
+			if _, _, edge := e.At(x, y); edge {
+				xs = append(xs, x)
+			}
+		}
+		if len(xs) != 2 || abs(xs[0]-16) > 1 || abs(xs[1]-47) > 1 {
+			t.Errorf("row %d: edges at %v, want one near 16 and 47", y, xs)
+		}
+	}
+	if _, _, edge := e.At(32, 24); edge {
+		t.Error("edge inside the flat square")
+	}
+	if mag, _, edge := e.At(0, 0); mag != 0 || edge {
+		t.Error("gradient outside the map")
+	}
+
+	// No pixel reaches the high threshold, so there are no edges to follow
+	for _, on := range Canny(sub, minEdge, math.Inf(1)).Edges {
+		if on {
+			t.Fatal("edge without a strong pixel")
+		}
+	}
+	if Sobel(sub).Edges != nil {
+		t.Error("Sobel returned edges")
+	}
+}
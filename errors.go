@@ -0,0 +1,40 @@
+package gobancrop
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the detection functions, possibly wrapped. Use errors.Is to check for them.
+var (
+	ErrNoBoardRegion      = errors.New("no wood region found")
+	ErrNotQuadrilateral   = errors.New("wood region is not a quadrilateral")
+	ErrGridNotFound       = errors.New("grid not found")
+	ErrInvalidBoardSize   = errors.New("invalid board size")
+	ErrInvalidSize        = errors.New("invalid size")
+	ErrDegenerateQuad     = errors.New("degenerate quadrilateral")
+	ErrSingularHomography = errors.New("singular homography")
+	ErrInvalidParameters  = errors.New("invalid detector parameters")
+	ErrNoLineFamilies     = errors.New("no grid line families found")
+)
+
+// GridNotFoundError is returned when no lattice of the wanted sizes fits the
+// lines seen within the coarse quad. It wraps ErrGridNotFound.
+type GridNotFoundError struct {
+	Quad  Quadrilateral // the quad that was searched, the coarse quad in the perspective of the lines seen
+	Sizes []int         // the board sizes that were tried
+
+	// Horizontal and Vertical are the number of lines seen in the sweep that came
+	// closest to one of Sizes, and Rows and Cols their positions across Quad,
+	// from 0 at the top or left edge to 1 at the bottom or right edge.
+	Horizontal, Vertical int
+	Rows, Cols           []float64
+}
+
+func (e *GridNotFoundError) Error() string {
+	return fmt.Sprintf("grid not found: h=%d v=%d", e.Horizontal, e.Vertical)
+}
+
+func (e *GridNotFoundError) Unwrap() error {
+	return ErrGridNotFound
+}
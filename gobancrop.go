@@ -1,119 +1,378 @@
 package gobancrop
 
 import (
-	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"image/draw"
-	"log"
+	"math"
+	"slices"
+	"sort"
 
 	"github.com/xyproto/palgen"
 )
 
-const maxLineWidth = 5
-
+// FindGoban segments the wood in img and returns the quadrilateral fitted to
+// the region most likely to be the board.
 func FindGoban(img *image.NRGBA) (Quadrilateral, error) {
-	log.Printf("FindGoban: scan bounds %v", img.Bounds())
-	b := img.Bounds()
-	minX, minY := float64(b.Max.X), float64(b.Max.Y)
-	maxX, maxY := 0.0, 0.0
-	found := false
-	for y := b.Min.Y; y < b.Max.Y; y += 2 {
-		for x := b.Min.X; x < b.Max.X; x += 2 {
-			if isWood(img.At(x, y)) {
-				found = true
-				xF, yF := float64(x), float64(y)
-				if xF < minX {
-					minX = xF
-				}
-				if xF > maxX {
-					maxX = xF
-				}
-				if yF < minY {
-					minY = yF
-				}
-				if yF > maxY {
-					maxY = yF
-				}
-			}
-		}
+	return NewDetector().FindGoban(img)
+}
+
+// FindGoban is like the package level FindGoban, with the parameters of d.
+func (d *Detector) FindGoban(img *image.NRGBA) (Quadrilateral, error) {
+	if err := d.Validate(); err != nil {
+		return Quadrilateral{}, err
+	}
+	regions := d.WoodRegions(img)
+	if len(regions) == 0 {
+		return Quadrilateral{}, ErrNoBoardRegion
+	}
+	r := regions[0]
+	if len(r.hull) < 4 {
+		return Quadrilateral{}, ErrNotQuadrilateral
+	}
+	d.log().Debug("FindGoban", "bounds", img.Bounds(), "regions", len(regions),
+		"best", r.Bounds, "score", r.Score, "quad", r.Quad)
+	return r.Quad, nil
+}
+
+// Grid is a board grid found by FindActualBoard.
+type Grid struct {
+	Quad       Quadrilateral   // outermost lines in source image coordinates
+	Size       int             // number of lines in each direction
+	Candidates []SizeCandidate // every board size that was tried, best first
+
+	// Distance from each fitted row and column to the line segment seen in the
+	// image, in cell widths. NaN marks lines that were not seen and were inferred.
+	RowResiduals, ColResiduals []float64
+
+	// Whether the edge of the board was seen beyond the top, right, bottom and
+	// left outer lines. A side is not visible when the image cuts the board off
+	// there, or when stones hide it.
+	Visible [4]bool
+
+	Confidence Confidence // how much the grid can be trusted
+}
+
+// SizeCandidate is a board size that was tried and how well it fits the image.
+type SizeCandidate struct {
+	Size       int
+	Confidence float64 // Confidence.Overall of the grid of this size
+}
+
+// Lattice returns the intersection lattice of the grid.
+func (g *Grid) Lattice() (*Lattice, error) {
+	return NewLattice(g.Quad, g.Size)
+}
+
+// FindActualBoard finds the grid lines of a size×size board within the coarse quad.
+// A size of 0 detects the board size among StandardSizes. The grid lines seen
+// within quad give the perspective of the board, and the lattice is found in a
+// warp that follows it, then fitted again in img with FitGrid, so quad can be as
+// rough as a box around the board.
+func FindActualBoard(img *image.NRGBA, quad Quadrilateral, size int) (*Grid, error) {
+	return NewDetector().FindActualBoard(img, quad, size)
+}
+
+// FindActualBoard is like the package level FindActualBoard, with the parameters
+// of d. A size of 0 detects the board size among d.Sizes.
+func (d *Detector) FindActualBoard(img *image.NRGBA, quad Quadrilateral, size int) (*Grid, error) {
+	sizes := d.Sizes
+	if size != 0 {
+		sizes = []int{size}
 	}
-	if !found {
-		return Quadrilateral{}, errors.New("no wood region found")
+	grids, err := d.findGrids(img, quad, sizes)
+	if err != nil {
+		return nil, err
 	}
-	q := Quadrilateral{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}}
-	log.Printf("FindGoban: bounds %v", q)
-	return q, nil
+	g := grids[0]
+	d.log().Debug("FindActualBoard: refined", "quad", g.Quad, "size", g.Size,
+		"rows", seenLines(g.RowResiduals), "cols", seenLines(g.ColResiduals), "confidence", g.Confidence)
+	return g, nil
 }
 
-func FindActualBoard(img *image.NRGBA, quad Quadrilateral) (Quadrilateral, error) {
-	log.Printf("FindActualBoard: input %v", quad)
+// FindCandidates is like FindActualBoard, but returns up to n grids, best first, or all of them if n is 0.
+// Other board sizes and other placements of the lines are all candidates, so a
+// caller can offer alternatives or pick the grid whose stones make sense.
+func FindCandidates(img *image.NRGBA, quad Quadrilateral, size, n int) ([]*Grid, error) {
+	return NewDetector().FindCandidates(img, quad, size, n)
+}
 
-	const warpSize = 512
-	warpedRaw, err := CropAndCorrect(img, quad, warpSize)
+// FindCandidates is like the package level FindCandidates, with the parameters of d.
+func (d *Detector) FindCandidates(img *image.NRGBA, quad Quadrilateral, size, n int) ([]*Grid, error) {
+	sizes := d.Sizes
+	if size != 0 {
+		sizes = []int{size}
+	}
+	grids, err := d.findGrids(img, quad, sizes)
 	if err != nil {
-		return Quadrilateral{}, fmt.Errorf("warp failed: %v", err)
+		return nil, err
+	}
+	if n > 0 && len(grids) > n {
+		grids = grids[:n]
 	}
+	return grids, nil
+}
 
-	// Palette reduction applied AFTER warp
-	reducedImg, err := palgen.Reduce(warpedRaw, 5)
+// findGrids finds every candidate grid of the given sizes within quad, ranked by confidence.
+// Each grid lists the best grid of every size as its Candidates.
+func (d *Detector) findGrids(img *image.NRGBA, quad Quadrilateral, sizes []int) ([]*Grid, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("%w: no board sizes given", ErrInvalidBoardSize)
+	}
+	for _, size := range sizes {
+		if size < 2 {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidBoardSize, size)
+		}
+	}
+
+	quad = d.searchQuad(img, quad)
+	warpedRaw, err := CropAndCorrect(img, quad, d.WarpSize)
 	if err != nil {
-		return Quadrilateral{}, fmt.Errorf("palette reduction failed: %v", err)
+		return nil, fmt.Errorf("warp failed: %w", err)
 	}
 
-	// Convert reduced image to *image.NRGBA
-	warped := image.NewNRGBA(reducedImg.Bounds())
-	draw.Draw(warped, warped.Bounds(), reducedImg, reducedImg.Bounds().Min, draw.Src)
+	d.stage("warped", func() image.Image { return warpedRaw })
+	warped := warpedRaw
+	if d.PaletteColors > 0 {
+		// Palette reduction applied AFTER warp
+		reducedImg, err := palgen.Reduce(warpedRaw, d.PaletteColors)
+		if err != nil {
+			return nil, fmt.Errorf("palette reduction failed: %w", err)
+		}
+
+		// Convert reduced image to *image.NRGBA
+		warped = image.NewNRGBA(reducedImg.Bounds())
+		draw.Draw(warped, warped.Bounds(), reducedImg, reducedImg.Bounds().Min, draw.Src)
+		d.stage("palette", func() image.Image { return warped })
+	}
 
 	w, h := warped.Bounds().Dx(), warped.Bounds().Dy()
-	log.Printf("subimage %dx%d", w, h)
+	d.log().Debug("FindActualBoard: warped", "quad", quad, "sizes", sizes, "width", w, "height", h)
+
+	cands, near, masks := d.findLines(warped, warpedRaw, w, h, sizes)
+	if len(cands) == 0 {
+		e := &GridNotFoundError{Quad: quad, Sizes: sizes, Horizontal: len(near.hs), Vertical: len(near.vs)}
+		e.Rows, e.Cols = segmentMids(near.hs, h), segmentMids(near.vs, w)
+		d.log().Debug("FindActualBoard: grid not found", "rows", e.Rows, "cols", e.Cols)
+		return nil, e
+	}
+
+	// Rank the sizes by how much each grid can be trusted
+	grids := make([]*Grid, len(cands))
+	for i, c := range cands {
+		r := candidateQuad(quad, c, w, h)
+		rowRes, colRes := c.yRes, c.xRes
+		// The warp is only as good as quad, fit the lines again in the image itself
+		if fit, fr, fc, err := FitGrid(img, r, c.size); err == nil {
+			r, rowRes, colRes = fit, fr, fc
+		} else {
+			d.log().Debug("FindActualBoard: keeping the grid found in the warp", "size", c.size, "err", err)
+		}
+		fitted := r
+		// The star points tell a grid a line off, and one of the wrong size, apart
+		ds := gridDots(img, r, c.size)
+		if r = d.fixHoshi(img, r, c.size, ds); r != fitted {
+			// The residuals tell fixEdges which outer lines the move left unseen
+			rowRes, colRes = nil, nil
+			if _, fr, fc, err := FitGrid(img, r, c.size); err == nil {
+				rowRes, colRes = fr, fc
+			}
+		}
+		r, visible := d.fixEdges(img, r, c.size, rowRes, colRes)
+		if r != fitted {
+			// Measured where the grid was before, measure again where it is now
+			ds = gridDots(img, r, c.size)
+			c = d.moveCandidate(c, masks, quad, r)
+			rowRes, colRes = c.yRes, c.xRes
+			if _, fr, fc, err := FitGrid(img, r, c.size); err == nil {
+				rowRes, colRes = fr, fc
+			}
+		}
+		c.hoshi, _, _ = hoshiMatch(ds, c.size, 0, 0)
+		grids[i] = &Grid{Quad: r, Size: c.size, RowResiduals: rowRes, ColResiduals: colRes, Visible: visible, Confidence: newConfidence(c, r, rowRes, colRes)}
+	}
+	sort.SliceStable(grids, func(i, j int) bool { return grids[i].Confidence.Overall > grids[j].Confidence.Overall })
+	// Placements a line off in the warp can end up on the same grid once fixed
+	unique := grids[:0]
+	for _, g := range grids {
+		if !slices.ContainsFunc(unique, func(o *Grid) bool { return sameGrid(o, g) }) {
+			unique = append(unique, g)
+		}
+	}
+	grids = unique
+	var sizeCands []SizeCandidate
+	seen := make(map[int]bool)
+	for _, g := range grids {
+		if !seen[g.Size] {
+			seen[g.Size] = true
+			sizeCands = append(sizeCands, SizeCandidate{Size: g.Size, Confidence: g.Confidence.Overall})
+		}
+	}
+	for _, g := range grids {
+		g.Candidates = sizeCands
+	}
+	return grids, nil
+}
+
+// searchQuad returns the part of the plane of the board that covers quad, from
+// the grid lines found within quad in img, so that the lines are evenly spaced in
+// its warp also when quad is a box around a board seen at an angle. Only where to
+// look comes from quad. It returns quad when no grid is found there.
+func (d *Detector) searchQuad(img *image.NRGBA, quad Quadrilateral) Quadrilateral {
+	b := quadBounds(expandQuad(quad, 0.05)).Intersect(img.Bounds())
+	fams, err := edgeLineFamilies(d.DetectEdges(img.SubImage(b).(*image.NRGBA)))
+	if err != nil {
+		return quad
+	}
+	g, rows, cols, err := gridQuad(fams)
+	if err != nil || squareIn(quad, g, max(rows, cols)) {
+		return quad
+	}
+	h, err := SquareToQuad(g)
+	if err != nil {
+		return quad
+	}
+	inv, err := h.Inverse()
+	if err != nil {
+		return quad
+	}
+	lo, hi := Point{math.Inf(1), math.Inf(1)}, Point{math.Inf(-1), math.Inf(-1)}
+	for _, p := range quad {
+		u := inv.Apply(p)
+		lo = Point{math.Min(lo.X, u.X), math.Min(lo.Y, u.Y)}
+		hi = Point{math.Max(hi.X, u.X), math.Max(hi.Y, u.Y)}
+	}
+	// A few lines in a corner of quad say little about the rest of it
+	if hi.X-lo.X > 4 || hi.Y-lo.Y > 4 {
+		return quad
+	}
+	// Lines hidden by stones aside, the grid ends a few cells past the lines found
+	m := 3 / float64(min(rows, cols)-1)
+	lo = Point{math.Max(lo.X, -m), math.Max(lo.Y, -m)}
+	hi = Point{math.Min(hi.X, 1+m), math.Min(hi.Y, 1+m)}
+	s := Quadrilateral{
+		interpQuadPoint(g, lo.X, lo.Y),
+		interpQuadPoint(g, hi.X, lo.Y),
+		interpQuadPoint(g, hi.X, hi.Y),
+		interpQuadPoint(g, lo.X, hi.Y),
+	}
+	// Corners of quad beyond the horizon of the board
+	if !quadBounds(s).In(quadBounds(expandQuad(quad, 1))) {
+		return quad
+	}
+	d.log().Debug("FindActualBoard: searching the plane of the lines", "quad", quad, "rows", rows, "cols", cols, "search", s)
+	return s
+}
+
+// squareIn reports whether the grid of n lines in g is a rectangle, to within
+// half a cell, in the warp of quad: whether quad already has its perspective.
+// Lines found by Hough can be a degree or two off on small boards.
+func squareIn(quad, g Quadrilateral, n int) bool {
+	h, err := SquareToQuad(quad)
+	if err != nil {
+		return false
+	}
+	inv, err := h.Inverse()
+	if err != nil {
+		return false
+	}
+	var p Quadrilateral
+	for i := range g {
+		p[i] = inv.Apply(g[i])
+	}
+	tol := math.Min(p[1].X-p[0].X, p[3].Y-p[0].Y) / float64(n-1) / 2
+	return math.Abs(p[0].Y-p[1].Y) < tol && math.Abs(p[3].Y-p[2].Y) < tol &&
+		math.Abs(p[0].X-p[3].X) < tol && math.Abs(p[1].X-p[2].X) < tol
+}
 
-	thr, _, darkFrac := autoSetup(warped)
-	log.Printf("thr=%d darkFrac=%.3f", thr, darkFrac)
+// moveCandidate puts the lines of c where the outer lines of r are in the warp of
+// quad, after a fix moved the grid, and measures c there again: the lattice
+// against the segments it was found from, its residuals, contrast and wood.
+func (d *Detector) moveCandidate(c lineCandidate, m warpMasks, quad, r Quadrilateral) lineCandidate {
+	h, err := SquareToQuad(quad)
+	if err != nil {
+		return c
+	}
+	inv, err := h.Inverse()
+	if err != nil {
+		return c
+	}
+	w, ht := float64(m.raw.Bounds().Dx()-1), float64(m.raw.Bounds().Dy()-1)
+	var p Quadrilateral
+	for i := range r {
+		u := inv.Apply(r[i])
+		p[i] = Point{u.X * w, u.Y * ht}
+	}
+	n := float64(c.size - 1)
+	ys, xs := make([]float64, c.size), make([]float64, c.size)
+	top, bottom := (p[0].Y+p[1].Y)/2, (p[3].Y+p[2].Y)/2
+	left, right := (p[0].X+p[3].X)/2, (p[1].X+p[2].X)/2
+	for k := range ys {
+		ys[k] = top + (bottom-top)*float64(k)/n
+		xs[k] = left + (right-left)*float64(k)/n
+	}
+	fy, _, _ := refineLattice(segmentCentres(c.segs.hs), ys, c.size)
+	fx, _, _ := refineLattice(segmentCentres(c.segs.vs), xs, c.size)
+	c.ys, c.xs, c.yRes, c.xRes = fy.lines, fx.lines, fy.residuals, fx.residuals
+	c.lattice = math.Sqrt(fy.score * fx.score)
+	d.measure(&c, m)
+	return c
+}
 
-	ys, xs := findLines(warped, w, h, thr, darkFrac)
-	log.Printf("lines h=%d v=%d", len(ys), len(xs))
+// sameGrid reports whether a and b are the same size and their corners are
+// within a quarter of a cell of each other.
+func sameGrid(a, b *Grid) bool {
+	if a.Size != b.Size {
+		return false
+	}
+	cell := dist(a.Quad[0], a.Quad[1]) / float64(a.Size-1)
+	for i := range a.Quad {
+		if dist(a.Quad[i], b.Quad[i]) > cell/4 {
+			return false
+		}
+	}
+	return true
+}
 
-	if len(ys) != 19 || len(xs) != 19 {
-		return Quadrilateral{}, fmt.Errorf("grid not found: h=%d v=%d", len(ys), len(xs))
+// seenLines counts the lines with a residual, the others were inferred.
+func seenLines(residuals []float64) int {
+	n := 0
+	for _, r := range residuals {
+		if !math.IsNaN(r) {
+			n++
+		}
 	}
+	return n
+}
 
+// candidateQuad maps the outer lines of c, found in the w×h warp of quad, back to the source image.
+func candidateQuad(quad Quadrilateral, c lineCandidate, w, h int) Quadrilateral {
+	ys, xs, last := c.ys, c.xs, c.size-1
 	tl := interpQuadPoint(quad, xs[0]/float64(w-1), ys[0]/float64(h-1))
-	tr := interpQuadPoint(quad, xs[18]/float64(w-1), ys[0]/float64(h-1))
-	br := interpQuadPoint(quad, xs[18]/float64(w-1), ys[18]/float64(h-1))
-	bl := interpQuadPoint(quad, xs[0]/float64(w-1), ys[18]/float64(h-1))
-	r := Quadrilateral{tl, tr, br, bl}
-
-	log.Printf("FindActualBoard: refined %v", r)
-	return r, nil
+	tr := interpQuadPoint(quad, xs[last]/float64(w-1), ys[0]/float64(h-1))
+	br := interpQuadPoint(quad, xs[last]/float64(w-1), ys[last]/float64(h-1))
+	bl := interpQuadPoint(quad, xs[0]/float64(w-1), ys[last]/float64(h-1))
+	return Quadrilateral{tl, tr, br, bl}
 }
 
 func CropAndCorrect(img *image.NRGBA, quad Quadrilateral, size int) (*image.NRGBA, error) {
-	log.Printf("CropAndCorrect: size=%d quad=%v", size, quad)
 	if size <= 0 {
-		return nil, errors.New("invalid size")
+		return nil, ErrInvalidSize
+	}
+	h, err := SquareToQuad(quad)
+	if err != nil {
+		return nil, err
 	}
 	out := image.NewNRGBA(image.Rect(0, 0, size, size))
 	for y := 0; y < size; y++ {
 		v := float64(y) / float64(size-1)
 		for x := 0; x < size; x++ {
 			u := float64(x) / float64(size-1)
-			src := Point{
-				X: (1-v)*((1-u)*quad[0].X+u*quad[1].X) + v*((1-u)*quad[3].X+u*quad[2].X),
-				Y: (1-v)*((1-u)*quad[0].Y+u*quad[1].Y) + v*((1-u)*quad[3].Y+u*quad[2].Y),
-			}
-			out.Set(x, y, sampleBilinear(img, src))
+			out.Set(x, y, sampleBilinear(img, h.Apply(Point{u, v})))
 		}
 	}
-	log.Print("CropAndCorrect: done")
 	return out, nil
 }
-
-// Loosened thresholds to better detect wood under palette-reduced conditions
-func isWood(c color.Color) bool {
-	r, g, b, _ := c.RGBA()
-	rf, gf, bf := float64(r)/65535, float64(g)/65535, float64(b)/65535
-	h, s, v := rgbToHSV(rf, gf, bf)
-	return h >= 10 && h <= 55 && s >= 0.15 && v >= 0.2
-}
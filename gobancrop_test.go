@@ -1,6 +1,10 @@
 package gobancrop
 
 import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
 	"image/png"
 	"math"
 	"os"
@@ -28,7 +32,10 @@ func TestGobancropPipeline(t *testing.T) {
 		t.Fatalf("failed to create output dir: %v", err)
 	}
 
-	const size = 256
+	const (
+		size      = 256
+		boardSize = 19
+	)
 	for _, ti := range testImages {
 		ti := ti // capture range
 		t.Run(ti.name, func(t *testing.T) {
@@ -51,13 +58,20 @@ func TestGobancropPipeline(t *testing.T) {
 			}
 
 			// 2) Refine grid detection or shrink fallback
-			quad2, err := FindActualBoard(img, quad)
+			var quad2 Quadrilateral
+			grid, err := FindActualBoard(img, quad, boardSize)
 			if err != nil {
 				t.Logf("FindActualBoard failed, using shrink fallback: %v", err)
-				quad2 = shrinkQuadAligned(quad)
+				if quad2, err = ShrinkQuad(quad, boardSize); err != nil {
+					t.Fatalf("ShrinkQuad: %v", err)
+				}
 			} else {
-				d1 := hypot(quad2[0], quad2[1])
-				d2 := hypot(quad2[1], quad2[2])
+				if grid.Size != boardSize {
+					t.Errorf("grid size = %d, want %d", grid.Size, boardSize)
+				}
+				quad2 = grid.Quad
+				d1 := dist(quad2[0], quad2[1])
+				d2 := dist(quad2[1], quad2[2])
 				ratio := d1 / d2
 				if ratio < 0.8 || ratio > 1.25 {
 					t.Errorf("board not square: ratio=%.2f (d1=%.1f d2=%.1f)", ratio, d1, d2)
@@ -93,6 +107,272 @@ func TestGobancropPipeline(t *testing.T) {
 	}
 }
 
-func hypot(a, b Point) float64 {
-	return math.Hypot(a.X-b.X, a.Y-b.Y)
+func TestCrop(t *testing.T) {
+	for _, ti := range testImages {
+		img, err := carveimg.LoadImage(ti.path)
+		if err != nil {
+			t.Fatalf("LoadImage(%s): %v", ti.path, err)
+		}
+		res, err := Crop(img, &Options{OutputSize: 300})
+		if err != nil {
+			t.Fatalf("%s: Crop: %v", ti.name, err)
+		}
+		if res.Fallback || res.Grid == nil {
+			t.Errorf("%s: grid not found", ti.name)
+		}
+		if res.BoardSize != 19 {
+			t.Errorf("%s: board size = %d, want 19", ti.name, res.BoardSize)
+		}
+		if res.Confidence < 0.8 || res.Confidence > 1 {
+			t.Errorf("%s: confidence = %v", ti.name, res.Confidence)
+		}
+		if b := res.Image.Bounds(); b.Dx() != 300 || b.Dy() != 300 {
+			t.Errorf("%s: cropped size = %v", ti.name, b)
+		}
+
+		// A debug overlay for every image, to see what went wrong when a crop does
+		overlay := Overlay(img, res)
+		if c := overlay.NRGBAAt(int(math.Round(res.Refined[0].X)), int(math.Round(res.Refined[0].Y))); c != OverlayRefinedColor {
+			t.Errorf("%s: overlay at the top left corner is %v, want the refined quad colour", ti.name, c)
+		}
+		writePNG(t, filepath.Join("output", strings.TrimSuffix(filepath.Base(ti.path), ".png")+"_overlay.png"), overlay)
+	}
+
+	img := syntheticBoard(19, 20, 40)
+	if out := Overlay(img, nil); out.Bounds() != img.Bounds() {
+		t.Errorf("overlay without a result is %v, want %v", out.Bounds(), img.Bounds())
+	}
+	for _, size := range []int{1, -3} {
+		if _, err := Crop(img, &Options{BoardSize: size}); !errors.Is(err, ErrInvalidBoardSize) {
+			t.Errorf("board size %d: %v, want ErrInvalidBoardSize", size, err)
+		}
+	}
+	if _, err := ShrinkQuad(syntheticQuad(19, 20, 40), 1); !errors.Is(err, ErrInvalidBoardSize) {
+		t.Errorf("ShrinkQuad to a single line: %v, want ErrInvalidBoardSize", err)
+	}
+}
+
+func TestFindGobanRotated(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 400))
+	sin, cos := math.Sincos(20 * math.Pi / 180)
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			// Board rotated by 20 degrees around the center, and a wooden bowl in the corner
+			dx, dy := float64(x)-200, float64(y)-200
+			u, v := dx*cos+dy*sin, -dx*sin+dy*cos
+			c := color.NRGBA{40, 90, 60, 255}
+			if math.Abs(u) <= 100 && math.Abs(v) <= 100 || x < 30 && y < 30 {
+				c = color.NRGBA{215, 170, 100, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	quad, err := FindGoban(img)
+	if err != nil {
+		t.Fatalf("FindGoban: %v", err)
+	}
+	for i, c := range [4][2]float64{{-100, -100}, {100, -100}, {100, 100}, {-100, 100}} {
+		want := Point{200 + c[0]*cos - c[1]*sin, 200 + c[0]*sin + c[1]*cos}
+		if d := dist(quad[i], want); d > 4 {
+			t.Errorf("corner %d = %v, want %v", i, quad[i], want)
+		}
+	}
+	mask, err := BoardMask(img)
+	if err != nil {
+		t.Fatalf("BoardMask: %v", err)
+	}
+	if mask.AlphaAt(10, 10).A != 0 || mask.AlphaAt(200, 200).A == 0 {
+		t.Error("board mask should cover the board and not the bowl")
+	}
+}
+
+func TestFindActualBoardKeystone(t *testing.T) {
+	img, h := angledBoard(Quadrilateral{{150, 60}, {500, 100}, {570, 430}, {80, 400}})
+	for name, coarse := range map[string]Quadrilateral{
+		"bounding box": {{80, 60}, {570, 60}, {570, 430}, {80, 430}},
+		"other angle":  {{220, 60}, {420, 70}, {620, 450}, {30, 430}},
+	} {
+		g, err := FindActualBoard(img, coarse, 0)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if g.Size != 19 {
+			t.Errorf("%s: size %d, want 19", name, g.Size)
+		}
+		for i, c := range unitSquare {
+			if p := h.Apply(Point{20 + 360*c.X, 20 + 360*c.Y}); dist(g.Quad[i], p) > 2 {
+				t.Errorf("%s: corner %d at %v, want %v", name, i, g.Quad[i], p)
+			}
+		}
+		if g.Confidence.Overall < 0.7 {
+			t.Errorf("%s: confidence %+v", name, g.Confidence)
+		}
+	}
+}
+
+func TestDetectionErrors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for i := range img.Pix {
+		img.Pix[i] = []uint8{40, 90, 60, 255}[i%4]
+	}
+	if _, err := FindGoban(img); !errors.Is(err, ErrNoBoardRegion) {
+		t.Errorf("FindGoban on felt: %v, want ErrNoBoardRegion", err)
+	}
+
+	// A single line each way, too few for any board
+	img = syntheticBoard(2, 200, 30)
+	draw.Draw(img, image.Rect(0, 200, 260, 261), image.NewUniform(img.At(100, 100)), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(200, 0, 261, 260), image.NewUniform(img.At(100, 100)), image.Point{}, draw.Src)
+	side := float64(img.Bounds().Dx() - 1)
+	quad := Quadrilateral{{0, 0}, {side, 0}, {side, side}, {0, side}}
+	_, err := FindActualBoard(img, quad, 19)
+	var gerr *GridNotFoundError
+	if !errors.As(err, &gerr) || !errors.Is(err, ErrGridNotFound) {
+		t.Fatalf("FindActualBoard: %v, want a GridNotFoundError", err)
+	}
+	if gerr.Horizontal != 1 || gerr.Vertical != 1 || len(gerr.Rows) != 1 || len(gerr.Cols) != 1 {
+		t.Fatalf("found h=%d v=%d rows=%v cols=%v, want 1 each", gerr.Horizontal, gerr.Vertical, gerr.Rows, gerr.Cols)
+	}
+	if want := 30.0 / 260; math.Abs(gerr.Rows[0]-want) > 0.01 {
+		t.Errorf("row at %.3f, want %.3f", gerr.Rows[0], want)
+	}
+
+	d := NewDetector()
+	d.WarpSize = 0
+	if _, err := d.FindGoban(img); !errors.Is(err, ErrInvalidParameters) {
+		t.Errorf("FindGoban with warp size 0: %v, want ErrInvalidParameters", err)
+	}
+}
+
+func TestFindLinesSizes(t *testing.T) {
+	for _, size := range []int{9, 13, 19} {
+		cell := 440 / (size - 1)
+		img := syntheticBoard(size, cell, 30)
+		w, h := img.Bounds().Dx(), img.Bounds().Dy()
+		cands, _, _ := NewDetector().findLines(img, img, w, h, StandardSizes)
+		if len(cands) == 0 {
+			t.Fatalf("%dx%d: no lines found", size, size)
+		}
+		if cands[0].size != size {
+			t.Fatalf("%dx%d: detected size %d (%+v)", size, size, cands[0].size, cands)
+		}
+		ys, xs := cands[0].ys, cands[0].xs
+		want := syntheticQuad(size, cell, 30)
+		for _, got := range [][]float64{ys, xs} {
+			if math.Abs(got[0]-want[0].X) > 1.5 || math.Abs(got[size-1]-want[2].X) > 1.5 {
+				t.Errorf("%dx%d: outer lines at %.1f and %.1f, want %.1f and %.1f",
+					size, size, got[0], got[size-1], want[0].X, want[2].X)
+			}
+		}
+	}
+}
+
+func TestFindLinesStones(t *testing.T) {
+	const size, cell, margin = 19, 24, 30
+	img := syntheticBoard(size, cell, margin)
+	// A late-game position: long walls of stones along and across the lines
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			switch {
+			case row%4 == 1 || (col*7+row*3)%5 == 0:
+				drawStone(img, margin+col*cell, margin+row*cell, cell/2-1, color.NRGBA{15, 15, 15, 255})
+			case row%4 == 2 || (col+row)%3 == 0:
+				drawStone(img, margin+col*cell, margin+row*cell, cell/2-1, color.NRGBA{235, 235, 230, 255})
+			}
+		}
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	cands, _, _ := NewDetector().findLines(img, img, w, h, []int{size})
+	if len(cands) == 0 {
+		t.Fatal("no lines found")
+	}
+	want := syntheticQuad(size, cell, margin)
+	c := cands[0]
+	for _, got := range [][]float64{c.ys, c.xs} {
+		if math.Abs(got[0]-want[0].X) > 1.5 || math.Abs(got[size-1]-want[2].X) > 1.5 {
+			t.Errorf("outer lines at %.1f and %.1f, want %.1f and %.1f", got[0], got[size-1], want[0].X, want[2].X)
+		}
+	}
+}
+
+func TestFitLatticeOutliers(t *testing.T) {
+	var segs [][2]int
+	for k := 0; k < 19; k++ {
+		if k == 0 || k == 5 {
+			continue // occluded
+		}
+		p := 30 + 25*k
+		segs = append(segs, [2]int{p - 1, p + 1})
+	}
+	// A label in the margin and a stone group between two lines
+	segs = append(segs, [2]int{8, 12}, [2]int{310, 316})
+	fit, ok := fitLattice(segs, 19, 512)
+	if !ok {
+		t.Fatal("no lattice found")
+	}
+	for k, l := range fit.lines {
+		if want := float64(30 + 25*k); math.Abs(l-want) > 0.5 {
+			t.Errorf("line %d at %.1f, want %.1f", k, l, want)
+		}
+	}
+	for k, r := range fit.residuals {
+		if inferred := k == 0 || k == 5; inferred != math.IsNaN(r) {
+			t.Errorf("line %d residual %v, inferred=%v", k, r, inferred)
+		}
+	}
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// syntheticBoard renders an empty size×size board with the given cell size and wooden margin.
+func syntheticBoard(size, cell, margin int) *image.NRGBA {
+	side := 2*margin + (size-1)*cell + 1
+	img := image.NewNRGBA(image.Rect(0, 0, side, side))
+	// Wood with a little grain
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			g := uint8((x*7 + y*13 + (x*y)%11) % 24)
+			img.SetNRGBA(x, y, color.NRGBA{208 + g/2, 165 + g/2, 90 + g, 255})
+		}
+	}
+	ink := color.NRGBA{20, 20, 20, 255}
+	end := margin + (size-1)*cell + 1
+	for i := 0; i < size; i++ {
+		p := margin + i*cell
+		for j := margin; j < end; j++ {
+			img.SetNRGBA(j, p, ink)
+			img.SetNRGBA(p, j, ink)
+		}
+	}
+	return img
+}
+
+func drawStone(img *image.NRGBA, cx, cy, r int, c color.NRGBA) {
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+func syntheticQuad(size, cell, margin int) Quadrilateral {
+	lo := float64(margin)
+	hi := lo + float64((size-1)*cell)
+	return Quadrilateral{{lo, lo}, {hi, lo}, {hi, hi}, {lo, hi}}
 }
@@ -0,0 +1,113 @@
+package gobancrop
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Hint tells the detection roughly where the board is, for images with several
+// boards or wooden things besides the board. Set one of the fields, when more are
+// set Quad is used before Rect and Rect before Seed.
+type Hint struct {
+	Seed *image.Point    // a pixel on the board
+	Rect image.Rectangle // a rectangle around the board, margins are fine
+	Quad *Quadrilateral  // the approximate corners of the board
+}
+
+// IsZero reports if the hint says nothing about where the board is.
+func (h Hint) IsZero() bool {
+	return h.Seed == nil && h.Rect.Empty() && h.Quad == nil
+}
+
+// hintMargin is how far beyond a Rect or Quad hint the wood is looked for, as a share of its size.
+const hintMargin = 0.1
+
+// FindGobanNear is like FindGoban, but only looks for the board where hint says.
+// When no wood is found around a Rect or Quad hint, the hint itself is returned,
+// grown a little in case it cuts off the outer lines, unless it is outside img.
+func FindGobanNear(img *image.NRGBA, hint Hint) (Quadrilateral, error) {
+	return NewDetector().FindGobanNear(img, hint)
+}
+
+// FindGobanNear is like the package level FindGobanNear, with the parameters of d.
+func (d *Detector) FindGobanNear(img *image.NRGBA, hint Hint) (Quadrilateral, error) {
+	if hint.IsZero() {
+		return d.FindGoban(img)
+	}
+	if err := d.Validate(); err != nil {
+		return Quadrilateral{}, err
+	}
+
+	if hint.Quad == nil && hint.Rect.Empty() {
+		// Only a seed, the board is the wood region under it
+		p := *hint.Seed
+		if !p.In(img.Bounds()) {
+			return Quadrilateral{}, ErrNoBoardRegion
+		}
+		for _, r := range d.WoodRegions(img) {
+			// Stones on the seed leave holes in the mask, but not in the hull
+			if r.Mask.AlphaAt(p.X, p.Y).A != 0 || insideConvex(r.hull, Point{float64(p.X), float64(p.Y)}) {
+				if len(r.hull) < 4 {
+					return Quadrilateral{}, ErrNotQuadrilateral
+				}
+				d.log().Debug("FindGobanNear: seed", "seed", p, "region", r.Bounds, "quad", r.Quad)
+				return r.Quad, nil
+			}
+		}
+		return Quadrilateral{}, ErrNoBoardRegion
+	}
+
+	approx := rectQuad(hint.Rect)
+	if hint.Quad != nil {
+		approx = *hint.Quad
+	}
+	bounds := quadBounds(approx)
+	m := image.Pt(int(float64(bounds.Dx())*hintMargin), int(float64(bounds.Dy())*hintMargin))
+	area := image.Rectangle{bounds.Min.Sub(m), bounds.Max.Add(m)}.Intersect(img.Bounds())
+	if area.Empty() {
+		return Quadrilateral{}, fmt.Errorf("%w: the hint %v is outside the image", ErrNoBoardRegion, bounds)
+	}
+	centre := quadCentre(approx)
+	sub := img.SubImage(area).(*image.NRGBA)
+	for _, r := range d.WoodRegions(sub) {
+		// The board is the wood around the middle of the hint, not a bowl next to it
+		if len(r.hull) >= 4 && insideConvex(r.hull, centre) {
+			d.log().Debug("FindGobanNear: hint", "area", area, "region", r.Bounds, "quad", r.Quad)
+			return r.Quad, nil
+		}
+	}
+	d.log().Debug("FindGobanNear: no wood at the hint, using it as it is", "quad", approx)
+	return expandQuad(approx, hintMargin/2), nil
+}
+
+// rectQuad returns the corners of r, clockwise from the top left.
+func rectQuad(r image.Rectangle) Quadrilateral {
+	x0, y0 := float64(r.Min.X), float64(r.Min.Y)
+	x1, y1 := float64(r.Max.X-1), float64(r.Max.Y-1)
+	return Quadrilateral{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+}
+
+// quadBounds returns the smallest rectangle of pixels containing q.
+func quadBounds(q Quadrilateral) image.Rectangle {
+	// Not image.Rect, which would swap the empty start around
+	r := image.Rectangle{image.Pt(math.MaxInt, math.MaxInt), image.Pt(math.MinInt, math.MinInt)}
+	for _, p := range q {
+		r.Min.X, r.Min.Y = min(r.Min.X, int(math.Floor(p.X))), min(r.Min.Y, int(math.Floor(p.Y)))
+		r.Max.X, r.Max.Y = max(r.Max.X, int(math.Floor(p.X))+1), max(r.Max.Y, int(math.Floor(p.Y))+1)
+	}
+	return r
+}
+
+func quadCentre(q Quadrilateral) Point {
+	return Point{(q[0].X + q[1].X + q[2].X + q[3].X) / 4, (q[0].Y + q[1].Y + q[2].Y + q[3].Y) / 4}
+}
+
+// expandQuad moves every corner of q away from its centre by frac of the distance.
+func expandQuad(q Quadrilateral, frac float64) Quadrilateral {
+	c := quadCentre(q)
+	for i, p := range q {
+		q[i] = Point{p.X + (p.X-c.X)*frac, p.Y + (p.Y-c.Y)*frac}
+	}
+	return q
+}
@@ -0,0 +1,117 @@
+package gobancrop
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/xyproto/carveimg"
+)
+
+// twoBoards renders a 19×19 board on the left and a 9×9 board on the right of a green table.
+func twoBoards() (img *image.NRGBA, left, right image.Rectangle) {
+	img = image.NewNRGBA(image.Rect(0, 0, 1000, 520))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{40, 90, 60, 255}), image.Point{}, draw.Src)
+	big, small := syntheticBoard(19, 24, 20), syntheticBoard(9, 36, 20)
+	left = big.Bounds().Add(image.Pt(20, 20))
+	right = small.Bounds().Add(image.Pt(600, 100))
+	draw.Draw(img, left, big, image.Point{}, draw.Src)
+	draw.Draw(img, right, small, image.Point{}, draw.Src)
+	return img, left, right
+}
+
+func TestFindGobanNear(t *testing.T) {
+	img, left, right := twoBoards()
+	near := func(q Quadrilateral, r image.Rectangle, tol float64) bool {
+		want := rectQuad(r)
+		for i := range q {
+			if dist(q[i], want[i]) > tol {
+				return false
+			}
+		}
+		return true
+	}
+
+	seed := image.Pt(right.Min.X+50, right.Min.Y+50)
+	if q, err := FindGobanNear(img, Hint{Seed: &seed}); err != nil || !near(q, right, 4) {
+		t.Errorf("seed on the right board: %v %v, want %v", q, err, right)
+	}
+	rough := image.Rect(0, 0, 560, 520)
+	if q, err := FindGobanNear(img, Hint{Rect: rough}); err != nil || !near(q, left, 4) {
+		t.Errorf("rectangle around the left board: %v %v, want %v", q, err, left)
+	}
+	seed = image.Pt(500, 10)
+	if _, err := FindGobanNear(img, Hint{Seed: &seed}); !errors.Is(err, ErrNoBoardRegion) {
+		t.Errorf("seed on the table: %v, want ErrNoBoardRegion", err)
+	}
+	// Nothing but table, the hint is all there is to go by
+	table := rectQuad(image.Rect(560, 20, 590, 50))
+	if q, err := FindGobanNear(img, Hint{Quad: &table}); err != nil || dist(q[0], table[0]) > 2 {
+		t.Errorf("quad on the table: %v %v, want about %v", q, err, table)
+	}
+	if _, err := FindGobanNear(img, Hint{Rect: image.Rect(-300, -300, -100, -100)}); !errors.Is(err, ErrNoBoardRegion) {
+		t.Errorf("rectangle outside the image: %v, want ErrNoBoardRegion", err)
+	}
+
+	res, err := Crop(img, &Options{Hint: Hint{Seed: &image.Point{right.Min.X + 50, right.Min.Y + 50}}})
+	if err != nil {
+		t.Fatalf("Crop: %v", err)
+	}
+	if res.Fallback || res.BoardSize != 9 {
+		t.Errorf("Crop with a seed on the 9×9 board found a %dx%d board (fallback %v)", res.BoardSize, res.BoardSize, res.Fallback)
+	}
+}
+
+func TestFindGobans(t *testing.T) {
+	img, left, right := twoBoards()
+	boards, err := FindGobans(img, &Options{OutputSize: 100})
+	if err != nil {
+		t.Fatalf("FindGobans: %v", err)
+	}
+	if len(boards) != 2 {
+		t.Fatalf("found %d boards, want 2", len(boards))
+	}
+	for i, want := range []struct {
+		size int
+		r    image.Rectangle
+	}{{19, left}, {9, right}} {
+		b := boards[i]
+		if b.BoardSize != want.size || !image.Pt(int(b.Refined[0].X), int(b.Refined[0].Y)).In(want.r) {
+			t.Errorf("board %d: %dx%d at %v, want %dx%d in %v", i, b.BoardSize, b.BoardSize, b.Refined[0], want.size, want.size, want.r)
+		}
+	}
+
+	// Four boards in two rows, the lower left one a little higher than the lower right one
+	img = image.NewNRGBA(image.Rect(0, 0, 700, 700))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{40, 90, 60, 255}), image.Point{}, draw.Src)
+	board := syntheticBoard(9, 30, 15)
+	at := []image.Point{{380, 30}, {20, 40}, {390, 380}, {30, 360}}
+	for _, p := range at {
+		draw.Draw(img, board.Bounds().Add(p), board, image.Point{}, draw.Src)
+	}
+	boards, err = FindGobans(img, nil)
+	if err != nil {
+		t.Fatalf("FindGobans: %v", err)
+	}
+	if len(boards) != 4 {
+		t.Fatalf("found %d boards, want 4", len(boards))
+	}
+	for i, k := range []int{1, 0, 3, 2} {
+		r := board.Bounds().Add(at[k])
+		if p := boards[i].Refined[0]; !image.Pt(int(p.X), int(p.Y)).In(r) {
+			t.Errorf("board %d at %v, want the one in %v", i, p, r)
+		}
+	}
+
+	for _, ti := range testImages[:2] {
+		img, err := carveimg.LoadImage(ti.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if boards, err := FindGobans(img, nil); err != nil || len(boards) != 1 {
+			t.Errorf("%s: %d boards, %v", ti.name, len(boards), err)
+		}
+	}
+}
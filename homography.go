@@ -0,0 +1,104 @@
+package gobancrop
+
+import (
+	"math"
+)
+
+// Homography is a 3x3 projective transform in row-major order.
+type Homography [9]float64
+
+// IdentityHomography returns the identity transform.
+func IdentityHomography() Homography {
+	return Homography{1, 0, 0, 0, 1, 0, 0, 0, 1}
+}
+
+// NewHomography computes the homography that maps each src corner onto the matching dst corner.
+func NewHomography(src, dst Quadrilateral) (Homography, error) {
+	// Solve the 8x8 system for h0..h7 with h8 fixed to 1
+	var a [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y := src[i].X, src[i].Y
+		u, v := dst[i].X, dst[i].Y
+		a[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		a[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return Homography{}, ErrDegenerateQuad
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < 8; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for c := col; c < 9; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+	var h Homography
+	for i := 0; i < 8; i++ {
+		h[i] = a[i][8] / a[i][i]
+	}
+	h[8] = 1
+	return h, nil
+}
+
+// SquareToQuad returns the homography mapping the unit square (0,0)-(1,1) onto q.
+func SquareToQuad(q Quadrilateral) (Homography, error) {
+	return NewHomography(unitSquare, q)
+}
+
+var unitSquare = Quadrilateral{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+
+// Apply maps p through the homography.
+func (h Homography) Apply(p Point) Point {
+	w := h[6]*p.X + h[7]*p.Y + h[8]
+	if w == 0 {
+		return Point{math.Inf(1), math.Inf(1)}
+	}
+	return Point{
+		X: (h[0]*p.X + h[1]*p.Y + h[2]) / w,
+		Y: (h[3]*p.X + h[4]*p.Y + h[5]) / w,
+	}
+}
+
+// Mul returns the composition h∘o, which applies o first and then h.
+func (h Homography) Mul(o Homography) Homography {
+	var r Homography
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[3*i+j] += h[3*i+k] * o[3*k+j]
+			}
+		}
+	}
+	return r
+}
+
+// Inverse returns the inverse transform.
+func (h Homography) Inverse() (Homography, error) {
+	a, b, c := h[0], h[1], h[2]
+	d, e, f := h[3], h[4], h[5]
+	g, k, l := h[6], h[7], h[8]
+	det := a*(e*l-f*k) - b*(d*l-f*g) + c*(d*k-e*g)
+	if math.Abs(det) < 1e-12 {
+		return Homography{}, ErrSingularHomography
+	}
+	inv := Homography{
+		e*l - f*k, c*k - b*l, b*f - c*e,
+		f*g - d*l, a*l - c*g, c*d - a*f,
+		d*k - e*g, b*g - a*k, a*e - b*d,
+	}
+	for i := range inv {
+		inv[i] /= det
+	}
+	return inv, nil
+}
@@ -0,0 +1,35 @@
+package gobancrop
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestHomographyRoundTrip(t *testing.T) {
+	quad := Quadrilateral{{12, 30}, {410, 5}, {450, 380}, {-4, 420}}
+	h, err := SquareToQuad(quad)
+	if err != nil {
+		t.Fatalf("SquareToQuad: %v", err)
+	}
+	for i, c := range unitSquare {
+		if p := h.Apply(c); dist(p, quad[i]) > 1e-6 {
+			t.Errorf("corner %d maps to %v, want %v", i, p, quad[i])
+		}
+	}
+	inv, err := h.Inverse()
+	if err != nil {
+		t.Fatalf("Inverse: %v", err)
+	}
+	for _, p := range []Point{{0.25, 0.75}, {0.5, 0.5}, {0.9, 0.1}} {
+		if q := inv.Apply(h.Apply(p)); math.Abs(q.X-p.X) > 1e-9 || math.Abs(q.Y-p.Y) > 1e-9 {
+			t.Errorf("round trip of %v gave %v", p, q)
+		}
+	}
+	if _, err := (Homography{}).Inverse(); !errors.Is(err, ErrSingularHomography) {
+		t.Errorf("Inverse of zero: %v, want ErrSingularHomography", err)
+	}
+	if _, err := SquareToQuad(Quadrilateral{{0, 0}, {1, 1}, {2, 2}, {3, 3}}); err == nil {
+		t.Error("expected error for collinear quad")
+	}
+}
@@ -0,0 +1,183 @@
+package gobancrop
+
+import (
+	"image"
+	"math"
+)
+
+// HoshiPoints returns the star points as zero-based (column, row) pairs for the
+// standard 9×9, 13×13 and 19×19 layouts, or nil for other sizes.
+func HoshiPoints(size int) [][2]int {
+	var lines []int
+	switch size {
+	case 9:
+		return [][2]int{{2, 2}, {6, 2}, {4, 4}, {2, 6}, {6, 6}}
+	case 13:
+		return [][2]int{{3, 3}, {9, 3}, {6, 6}, {3, 9}, {9, 9}}
+	case 19:
+		lines = []int{3, 9, 15}
+	default:
+		return nil
+	}
+	var pts [][2]int
+	for _, r := range lines {
+		for _, c := range lines {
+			pts = append(pts, [2]int{c, r})
+		}
+	}
+	return pts
+}
+
+// Hoshi is a star point of a lattice and how much of a dot was seen on it.
+type Hoshi struct {
+	Col, Row int
+	Point    Point
+	Dot      float64 // see Lattice.Dot
+}
+
+// minDot is the Dot from which an intersection counts as having a dot. The dots
+// of screenshots can be little wider than the lines.
+const minDot = 0.1
+
+// Hoshi looks for the dots at the standard star points of l in img. It returns
+// nil for sizes without star points.
+func (l *Lattice) Hoshi(img *image.NRGBA) []Hoshi {
+	var hs []Hoshi
+	for _, p := range HoshiPoints(l.Size) {
+		hs = append(hs, Hoshi{Col: p[0], Row: p[1], Point: l.Point(p[0], p[1]), Dot: l.Dot(img, p[0], p[1])})
+	}
+	return hs
+}
+
+// Dot returns how dark img is just off the lines at the intersection col, row,
+// from 0 for a plain crossing to 1 for as dark as the lines, as in a star point
+// dot. Thin lines blur into the wood next to them, so the darkness of each line
+// just beside it, away from the crossing, is taken off. It is NaN when a stone
+// covers the intersection or the lines can not be told from the wood.
+func (l *Lattice) Dot(img *image.NRGBA, col, row int) float64 {
+	const off = 0.08 // from each line, in cells, inside even a small dot
+	n := float64(l.Size - 1)
+	at := func(dc, dr float64) float64 {
+		return lumAt(img, l.h.Apply(Point{(float64(col) + dc) / n, (float64(row) + dr) / n}))
+	}
+	ring := func(offs [][2]float64) float64 {
+		vals := make([]float64, 0, len(offs))
+		for _, o := range offs {
+			vals = append(vals, at(o[0], o[1]))
+		}
+		return median(vals)
+	}
+	diagonal := func(d float64) float64 { return ring([][2]float64{{d, d}, {-d, d}, {-d, -d}, {d, -d}}) }
+	line := ring([][2]float64{{0.3, 0}, {0, 0.3}, {-0.3, 0}, {0, -0.3}})
+	wood := diagonal(0.5)
+	if wood-line < fitContrast {
+		return math.NaN()
+	}
+	// A black stone is dark just off the lines too, but unlike a dot it reaches further
+	if wood-diagonal(0.25) > (wood-line)/2 {
+		return math.NaN()
+	}
+	beside := wood - ring([][2]float64{{off, 0.3}, {-off, 0.3}, {0.3, off}, {0.3, -off}, {off, -0.3}, {-off, -0.3}, {-0.3, off}, {-0.3, -off}})
+	beside = math.Max(0, beside)
+	return clamp01((wood - diagonal(off) - 2*beside) / (wood - line - 2*beside))
+}
+
+// dots returns Dot for every intersection of l, by row and column.
+func (l *Lattice) dots(img *image.NRGBA) [][]float64 {
+	ds := make([][]float64, l.Size)
+	for r := range ds {
+		ds[r] = make([]float64, l.Size)
+		for c := range ds[r] {
+			ds[r][c] = l.Dot(img, c, r)
+		}
+	}
+	return ds
+}
+
+// hoshiMatch compares how often dots are seen at the star points of a size×size
+// lattice, moved by dc columns and dr rows, with how often they are seen at the
+// other intersections. The result is in [-1, 1], closer to 0 when few of the
+// star points could be seen, with the number of star points that had a dot, and
+// ok false if none of them could be seen.
+func hoshiMatch(ds [][]float64, size, dc, dr int) (s float64, hits int, ok bool) {
+	star := make(map[[2]int]bool)
+	for _, p := range HoshiPoints(size) {
+		star[[2]int{p[0] + dc, p[1] + dr}] = true
+	}
+	var starSeen, otherSeen, otherHits int
+	for r, row := range ds {
+		for c, d := range row {
+			if math.IsNaN(d) {
+				continue
+			}
+			if star[[2]int{c, r}] {
+				starSeen++
+				if d >= minDot {
+					hits++
+				}
+			} else {
+				otherSeen++
+				if d >= minDot {
+					otherHits++
+				}
+			}
+		}
+	}
+	if starSeen == 0 {
+		return 0, 0, false
+	}
+	s = float64(hits) / float64(starSeen)
+	if otherSeen > 0 {
+		s -= float64(otherHits) / float64(otherSeen)
+	}
+	// One or two star points that could be seen say little either way
+	return s * math.Min(1, float64(starSeen)/3), hits, true
+}
+
+// gridDots returns Dot for every intersection of the size×size grid in quad, by
+// row and column, or nil when the size has no star points.
+func gridDots(img *image.NRGBA, quad Quadrilateral, size int) [][]float64 {
+	if HoshiPoints(size) == nil {
+		return nil
+	}
+	l, err := NewLattice(quad, size)
+	if err != nil {
+		return nil
+	}
+	return l.dots(img)
+}
+
+// fixHoshi moves the grid in quad by a line when the star point dots, ds as from
+// gridDots, are seen one line off from where the lattice puts them.
+func (d *Detector) fixHoshi(img *image.NRGBA, quad Quadrilateral, size int, ds [][]float64) Quadrilateral {
+	s, _, ok := hoshiMatch(ds, size, 0, 0)
+	if !ok {
+		return quad
+	}
+	best, bestC, bestR := s, 0, 0
+	for dr := -1; dr <= 1; dr++ {
+		for dc := -1; dc <= 1; dc++ {
+			// A clear match, both ways: most dots on the moved star points and few elsewhere
+			if ms, hits, ok := hoshiMatch(ds, size, dc, dr); ok && hits >= 3 && ms > best+0.3 {
+				best, bestC, bestR = ms, dc, dr
+			}
+		}
+	}
+	if bestC == 0 && bestR == 0 {
+		return quad
+	}
+	l, err := NewLattice(quad, size)
+	if err != nil {
+		return quad
+	}
+	n := float64(size - 1)
+	var moved Quadrilateral
+	for i, c := range unitSquare {
+		moved[i] = l.h.Apply(Point{c.X + float64(bestC)/n, c.Y + float64(bestR)/n})
+	}
+	d.log().Debug("FindActualBoard: star points are a line off", "size", size, "cols", bestC, "rows", bestR, "match", best)
+	if fit, _, _, err := FitGrid(img, moved, size); err == nil {
+		moved = fit
+	}
+	return moved
+}
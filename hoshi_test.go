@@ -0,0 +1,67 @@
+package gobancrop
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestHoshi(t *testing.T) {
+	const cell, margin = 20, 40
+	img := syntheticBoard(19, cell, margin)
+	ink := color.NRGBA{20, 20, 20, 255}
+	for _, p := range HoshiPoints(19) {
+		drawStone(img, margin+p[0]*cell, margin+p[1]*cell, 3, ink)
+	}
+	drawStone(img, margin+5*cell, margin+5*cell, 9, color.NRGBA{15, 15, 15, 255})
+	drawStone(img, margin+6*cell, margin+5*cell, 9, color.NRGBA{235, 235, 235, 255})
+
+	l, _ := NewLattice(syntheticQuad(19, cell, margin), 19)
+	hs := l.Hoshi(img)
+	if len(hs) != 9 {
+		t.Fatalf("%d star points, want 9", len(hs))
+	}
+	for _, h := range hs {
+		if !(h.Dot >= 0.5) {
+			t.Errorf("star point (%d,%d): dot %.2f", h.Col, h.Row, h.Dot)
+		}
+	}
+	if d := l.Dot(img, 4, 4); d > 0.05 {
+		t.Errorf("plain crossing: dot %.2f", d)
+	}
+	for _, c := range []int{5, 6} {
+		if d := l.Dot(img, c, 5); !math.IsNaN(d) {
+			t.Errorf("stone at (%d,5): dot %.2f, want NaN", c, d)
+		}
+	}
+	if s, hits, ok := hoshiMatch(l.dots(img), 19, 0, 0); !ok || hits != 9 || s < 0.99 {
+		t.Errorf("hoshiMatch = %.2f, %d, %v", s, hits, ok)
+	}
+
+	// A grid a column to the right has its star points next to the dots
+	off := syntheticQuad(19, cell, margin)
+	for i := range off {
+		off[i].X += cell
+	}
+	quad := NewDetector().fixHoshi(img, off, 19, gridDots(img, off, 19))
+	want := syntheticQuad(19, cell, margin)
+	for i := range quad {
+		if dist(quad[i], want[i]) > 0.5 {
+			t.Errorf("corner %d at %v, want %v", i, quad[i], want[i])
+		}
+	}
+	if s, _, ok := hoshiMatch(gridDots(img, quad, 19), 19, 0, 0); !ok || s < 0.99 {
+		t.Errorf("match after fixHoshi %.2f, %v", s, ok)
+	}
+
+	g, err := FindActualBoard(img, Quadrilateral{{0, 0}, {440, 0}, {440, 440}, {0, 440}}, 0)
+	if err != nil {
+		t.Fatalf("FindActualBoard: %v", err)
+	}
+	if g.Size != 19 || g.Confidence.Hoshi < 0.99 {
+		t.Errorf("found a %dx%d grid, hoshi confidence %.2f", g.Size, g.Size, g.Confidence.Hoshi)
+	}
+	if HoshiPoints(15) != nil {
+		t.Error("star points for a 15×15 board")
+	}
+}
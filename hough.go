@@ -0,0 +1,520 @@
+package gobancrop
+
+import (
+	"image"
+	"math"
+	"slices"
+	"sort"
+)
+
+// HoughLine is the infinite line X·cos(Theta) + Y·sin(Theta) = Rho in image
+// coordinates. Theta is in [-π/4, 3π/4), so horizontal and vertical lines are
+// well away from where the angle wraps around.
+type HoughLine struct {
+	Theta, Rho float64
+	Votes      int // edge pixels along the line
+}
+
+// homogeneous returns the line as a homogeneous 3-vector.
+func (l HoughLine) homogeneous() [3]float64 {
+	return [3]float64{math.Cos(l.Theta), math.Sin(l.Theta), -l.Rho}
+}
+
+// Intersect returns the point where l and o cross, false if they are parallel.
+func (l HoughLine) Intersect(o HoughLine) (Point, bool) {
+	a, b := l.homogeneous(), o.homogeneous()
+	p := cross(a, b)
+	if math.Abs(p[2]) < 1e-9 {
+		return Point{}, false
+	}
+	return Point{p[0] / p[2], p[1] / p[2]}, true
+}
+
+// LineFamily is a set of grid lines running in one direction, which perspective
+// makes converge to a vanishing point.
+type LineFamily struct {
+	Lines     []HoughLine // ordered across the family
+	Vanishing [3]float64  // homogeneous vanishing point, the third coordinate is 0 for parallel lines
+}
+
+// VanishingPoint returns where the lines of f meet, false if they are parallel.
+func (f LineFamily) VanishingPoint() (Point, bool) {
+	v := f.Vanishing
+	if math.Abs(v[2]) < 1e-9*math.Hypot(v[0], v[1]) {
+		return Point{}, false
+	}
+	return Point{v[0] / v[2], v[1] / v[2]}, true
+}
+
+// houghThetaBins is the number of angles lines are looked for at, half a degree each.
+const houghThetaBins = 360
+
+// HoughLines finds the long straight lines in img, strongest first. Each edge
+// pixel only votes for lines close to square with its gradient, and both sides
+// of a thin line count as one line.
+func HoughLines(img *image.NRGBA) []HoughLine {
+	return edgeLines(DetectEdges(img))
+}
+
+// edgeLines finds the lines through the edges of e, in the coordinates of the image.
+func edgeLines(e *EdgeMap) []HoughLine {
+	w, h := e.Rect.Dx(), e.Rect.Dy()
+	lines := houghLines(e.Edges, e.Orientation, w, h, max(20, min(w, h)/10))
+	for i := range lines {
+		l := &lines[i]
+		l.Rho += float64(e.Rect.Min.X)*math.Cos(l.Theta) + float64(e.Rect.Min.Y)*math.Sin(l.Theta)
+	}
+	return lines
+}
+
+// houghLines votes every edge pixel into a theta-rho accumulator and returns the
+// local maxima with at least minVotes, strongest first. Rho is counted from the
+// middle of the image in the accumulator, where the lines of a grid in perspective
+// are further apart than near a corner.
+func houghLines(edges []bool, dir []float64, w, h, minVotes int) []HoughLine {
+	cx, cy := float64(w)/2, float64(h)/2
+	diag := int(math.Ceil(math.Hypot(cx, cy))) + 1
+	nr := 2*diag + 1
+	step := math.Pi / houghThetaBins
+	cos, sin := make([]float64, houghThetaBins), make([]float64, houghThetaBins)
+	for t := range cos {
+		cos[t], sin[t] = math.Cos(thetaOf(t)), math.Sin(thetaOf(t))
+	}
+	acc := make([]int32, houghThetaBins*nr)
+	for i, e := range edges {
+		if !e {
+			continue
+		}
+		x, y := float64(i%w)-cx, float64(i/w)-cy
+		t0 := int(math.Floor((wrapTheta(dir[i]) + math.Pi/4) / step))
+		// On a stepped, near-axis edge the gradient direction can be a few degrees off,
+		// vote for the angles within 4° too
+		for dt := -8; dt <= 8; dt++ {
+			t := (t0 + dt + houghThetaBins) % houghThetaBins
+			r := int(math.Round(x*cos[t]+y*sin[t])) + diag
+			acc[t*nr+r]++
+		}
+	}
+
+	// Along a long line the rho of a neighbouring angle bin drifts by a pixel or
+	// so, count the votes of the neighbouring rhos too
+	box := make([]int32, len(acc))
+	for t := 0; t < houghThetaBins; t++ {
+		for r := 1; r < nr-1; r++ {
+			i := t*nr + r
+			box[i] = acc[i-1] + acc[i] + acc[i+1]
+		}
+	}
+	acc = box
+
+	// Both sides of a line and the smeared votes around it are one line
+	const dtMax, drMax = 6, 4
+	var lines []HoughLine
+	for t := 0; t < houghThetaBins; t++ {
+		for r := 0; r < nr; r++ {
+			v := acc[t*nr+r]
+			if int(v) < minVotes {
+				continue
+			}
+			peak := true
+			var sw, st, sr float64
+			for dt := -dtMax; dt <= dtMax && peak; dt++ {
+				for dr := -drMax; dr <= drMax; dr++ {
+					tt, rr := t+dt, r+dr
+					if tt < 0 || tt >= houghThetaBins || rr < 0 || rr >= nr {
+						continue
+					}
+					o := acc[tt*nr+rr]
+					// Of equal neighbours, the first one is the peak
+					if o > v || o == v && (dt < 0 || dt == 0 && dr < 0) {
+						peak = false
+						break
+					}
+					if dt >= -1 && dt <= 1 && dr >= -1 && dr <= 1 {
+						sw, st, sr = sw+float64(o), st+float64(o)*float64(dt), sr+float64(o)*float64(dr)
+					}
+				}
+			}
+			if peak {
+				theta := thetaOf(t) + st/sw*step
+				lines = append(lines, HoughLine{
+					Theta: theta,
+					Rho:   float64(r-diag) + sr/sw + cx*math.Cos(theta) + cy*math.Sin(theta),
+					Votes: int(v),
+				})
+			}
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Votes > lines[j].Votes })
+	return lines
+}
+
+// thetaOf returns the angle of the middle of theta bin t.
+func thetaOf(t int) float64 {
+	return -math.Pi/4 + (float64(t)+0.5)*math.Pi/houghThetaBins
+}
+
+// wrapTheta maps an angle into [-π/4, 3π/4), where a line and its opposite direction are the same.
+func wrapTheta(a float64) float64 {
+	a = math.Mod(a+math.Pi/4, math.Pi)
+	if a < 0 {
+		a += math.Pi
+	}
+	return a - math.Pi/4
+}
+
+// angleDelta returns the difference in radians between two line directions, in [0, π/2].
+func angleDelta(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), math.Pi)
+	return math.Min(d, math.Pi-d)
+}
+
+// LineFamilies finds the lines in img and splits them into the two directions of
+// a board grid, each with the vanishing point its lines converge to. Lines that
+// do not run towards the vanishing point of their family are left out.
+func LineFamilies(img *image.NRGBA) ([2]LineFamily, error) {
+	return edgeLineFamilies(DetectEdges(img))
+}
+
+// edgeLineFamilies is LineFamilies for the edges of an image.
+func edgeLineFamilies(e *EdgeMap) ([2]LineFamily, error) {
+	b := e.Rect
+	scale := math.Hypot(float64(b.Dx()), float64(b.Dy()))
+	centre := Point{float64(b.Min.X+b.Max.X) / 2, float64(b.Min.Y+b.Max.Y) / 2}
+	return lineFamilies(edgeLines(e), scale, centre)
+}
+
+// lineFamilies splits lines into the two directions of a grid by the vanishing
+// points they run towards, starting from the two strongest directions at least
+// 30° apart. Under perspective the lines of a family can spread over a wide range
+// of angles, so they are not told apart by angle alone. scale and centre are the
+// size and middle of the image, which keep the vanishing point fit well conditioned.
+func lineFamilies(lines []HoughLine, scale float64, centre Point) ([2]LineFamily, error) {
+	var fams [2]LineFamily
+	first, ok := strongestAngle(lines, math.NaN())
+	if !ok {
+		return fams, ErrNoLineFamilies
+	}
+	fams[0].Lines = vanishingFamily(lines, first, centre)
+	var rest []HoughLine
+	for _, l := range lines {
+		if !slices.Contains(fams[0].Lines, l) {
+			rest = append(rest, l)
+		}
+	}
+	second, ok := strongestAngle(rest, first)
+	if !ok {
+		return fams, ErrNoLineFamilies
+	}
+	fams[1].Lines = vanishingFamily(rest, second, centre)
+	for i := range fams {
+		f := &fams[i]
+		for pass := 0; pass < 2; pass++ {
+			if len(f.Lines) < 3 {
+				return fams, ErrNoLineFamilies
+			}
+			f.Vanishing = vanishingPoint(f.Lines, scale)
+			f.Lines = convergingLines(f.Lines, f.Vanishing, centre)
+		}
+		if len(f.Lines) < 3 {
+			return fams, ErrNoLineFamilies
+		}
+		f.Vanishing = vanishingPoint(f.Lines, scale)
+	}
+	// Order each family across itself, along the strongest line of the other one
+	for i := range fams {
+		ref := fams[1-i].Lines[0]
+		for _, l := range fams[1-i].Lines {
+			if l.Votes > ref.Votes {
+				ref = l
+			}
+		}
+		fams[i].Lines = orderAlong(fams[i].Lines, ref)
+	}
+	return fams, nil
+}
+
+// strongestAngle returns the direction most votes of lines run in, at least 30°
+// from not unless it is NaN, and false if there is none.
+func strongestAngle(lines []HoughLine, not float64) (float64, bool) {
+	var hist [houghThetaBins]float64
+	bin := func(theta float64) int {
+		return clampInt(int((wrapTheta(theta)+math.Pi/4)/math.Pi*houghThetaBins), 0, houghThetaBins-1)
+	}
+	for _, l := range lines {
+		for d := -6; d <= 6; d++ {
+			hist[(bin(l.Theta)+d+houghThetaBins)%houghThetaBins] += float64(l.Votes)
+		}
+	}
+	best := -1
+	for t := range hist {
+		if !math.IsNaN(not) && angleDelta(thetaOf(t), not) < math.Pi/6 {
+			continue
+		}
+		if best < 0 || hist[t] > hist[best] {
+			best = t
+		}
+	}
+	if best < 0 || hist[best] == 0 {
+		return 0, false
+	}
+	return thetaOf(best), true
+}
+
+// vanishingFamily returns the lines that run towards the same vanishing point as
+// the lines within 10° of theta. Every pair of the strongest of those proposes a
+// vanishing point, and the one that the most votes run towards, within a degree
+// and a half, wins.
+func vanishingFamily(lines []HoughLine, theta float64, centre Point) []HoughLine {
+	const maxSeeds, tol = 30, 1.5 * math.Pi / 180
+	var seeds []HoughLine
+	for _, l := range lines {
+		if angleDelta(l.Theta, theta) <= math.Pi/18 && len(seeds) < maxSeeds {
+			seeds = append(seeds, l)
+		}
+	}
+	var best []HoughLine
+	bestVotes := 0
+	for i := range seeds {
+		for j := i + 1; j < len(seeds); j++ {
+			vp := cross(seeds[i].homogeneous(), seeds[j].homogeneous())
+			var kept []HoughLine
+			votes := 0
+			for _, l := range lines {
+				if vanishingDeviation(l, vp, centre) <= tol {
+					kept = append(kept, l)
+					votes += l.Votes
+				}
+			}
+			if votes > bestVotes {
+				best, bestVotes = kept, votes
+			}
+		}
+	}
+	return best
+}
+
+// vanishingPoint returns the homogeneous point closest to all lines, weighted by
+// their votes: the eigenvector of the smallest eigenvalue of Σ v·l·lᵀ. Coordinates
+// are divided by scale during the fit.
+func vanishingPoint(lines []HoughLine, scale float64) [3]float64 {
+	m := [][]float64{make([]float64, 3), make([]float64, 3), make([]float64, 3)}
+	for _, l := range lines {
+		h := l.homogeneous()
+		h[2] /= scale
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				m[i][j] += float64(l.Votes) * h[i] * h[j]
+			}
+		}
+	}
+	v := smallestEigenvector(m)
+	return [3]float64{v[0], v[1], v[2] / scale}
+}
+
+// convergingLines drops the lines that pass the vanishing point vp by more than
+// two degrees, or three times the median, seen from where they are nearest to centre.
+func convergingLines(lines []HoughLine, vp [3]float64, centre Point) []HoughLine {
+	dev := make([]float64, len(lines))
+	for i, l := range lines {
+		dev[i] = vanishingDeviation(l, vp, centre)
+	}
+	limit := math.Max(2*math.Pi/180, 3*median(append([]float64(nil), dev...)))
+	var kept []HoughLine
+	for i, l := range lines {
+		if dev[i] <= limit {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// vanishingDeviation returns the angle by which l misses the vanishing point vp,
+// seen from where l is nearest to centre.
+func vanishingDeviation(l HoughLine, vp [3]float64, centre Point) float64 {
+	c, s := math.Cos(l.Theta), math.Sin(l.Theta)
+	off := centre.X*c + centre.Y*s - l.Rho
+	foot := Point{centre.X - off*c, centre.Y - off*s}
+	// Direction from the foot to the vanishing point, also when it is at infinity
+	dx, dy := vp[0]-foot.X*vp[2], vp[1]-foot.Y*vp[2]
+	return angleDelta(math.Atan2(dy, dx), l.Theta+math.Pi/2)
+}
+
+// orderAlong sorts lines by where they cross ref.
+func orderAlong(lines []HoughLine, ref HoughLine) []HoughLine {
+	pos := make(map[int]float64, len(lines))
+	dx, dy := -math.Sin(ref.Theta), math.Cos(ref.Theta)
+	for i, l := range lines {
+		if p, ok := l.Intersect(ref); ok {
+			pos[i] = p.X*dx + p.Y*dy
+		}
+	}
+	idx := make([]int, 0, len(lines))
+	for i := range lines {
+		if _, ok := pos[i]; ok {
+			idx = append(idx, i)
+		}
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return pos[idx[a]] < pos[idx[b]] })
+	out := make([]HoughLine, len(idx))
+	for k, i := range idx {
+		out[k] = lines[i]
+	}
+	return out
+}
+
+// gridRun returns the longest run of consecutive lines, ordered across their
+// family, whose spacing along ref changes steadily, as the spacing of grid lines
+// under perspective does.
+func gridRun(lines []HoughLine, ref HoughLine) []HoughLine {
+	dx, dy := -math.Sin(ref.Theta), math.Cos(ref.Theta)
+	var pos []float64
+	var kept []HoughLine
+	for _, l := range lines {
+		p, ok := l.Intersect(ref)
+		if !ok {
+			continue
+		}
+		t := p.X*dx + p.Y*dy
+		// Lines a few pixels apart are the same line found twice
+		if n := len(kept); n > 0 && math.Abs(t-pos[n-1]) < 3 {
+			if l.Votes > kept[n-1].Votes {
+				kept[n-1], pos[n-1] = l, t
+			}
+			continue
+		}
+		kept, pos = append(kept, l), append(pos, t)
+	}
+	bestStart, bestLen := 0, min(1, len(kept))
+	for i := 0; i+1 < len(kept); i++ {
+		j := i + 1
+		for j+1 < len(kept) {
+			ratio := (pos[j+1] - pos[j]) / (pos[j] - pos[j-1])
+			if ratio < 0.75 || ratio > 1/0.75 {
+				break
+			}
+			j++
+		}
+		if j-i+1 > bestLen {
+			bestStart, bestLen = i, j-i+1
+		}
+	}
+	return kept[bestStart : bestStart+bestLen]
+}
+
+// FindGobanLines finds the board from its grid lines alone, without looking at
+// the colour of the wood, so it also works for painted or grey boards and angled
+// photos. The quad returned runs half a cell outside the outer grid lines found,
+// to be refined by FindActualBoard like the quad from FindGoban.
+func FindGobanLines(img *image.NRGBA) (Quadrilateral, error) {
+	return NewDetector().FindGobanLines(img)
+}
+
+// FindGobanLines is like the package level FindGobanLines, logging to d.
+func (d *Detector) FindGobanLines(img *image.NRGBA) (Quadrilateral, error) {
+	fams, err := edgeLineFamilies(d.DetectEdges(img))
+	if err != nil {
+		return Quadrilateral{}, err
+	}
+	q, rows, cols, err := gridQuad(fams)
+	if err != nil {
+		return Quadrilateral{}, err
+	}
+	d.log().Debug("FindGobanLines", "rows", rows, "cols", cols, "quad", q)
+	// Half a cell more on each side, in the plane of the board so the grid stays evenly spaced
+	u := 0.5 / float64(cols-1)
+	v := 0.5 / float64(rows-1)
+	return Quadrilateral{
+		interpQuadPoint(q, -u, -v),
+		interpQuadPoint(q, 1+u, -v),
+		interpQuadPoint(q, 1+u, 1+v),
+		interpQuadPoint(q, -u, 1+v),
+	}, nil
+}
+
+// gridQuad returns the quad where the outer lines of the grid runs of fams meet,
+// with the number of lines in the run of each family.
+func gridQuad(fams [2]LineFamily) (q Quadrilateral, rows, cols int, err error) {
+	var runs [2][]HoughLine
+	for i := range fams {
+		ref := fams[1-i].Lines[len(fams[1-i].Lines)/2]
+		runs[i] = gridRun(fams[i].Lines, ref)
+		if len(runs[i]) < 3 {
+			return q, 0, 0, ErrNoLineFamilies
+		}
+	}
+	a0, a1 := runs[0][0], runs[0][len(runs[0])-1]
+	b0, b1 := runs[1][0], runs[1][len(runs[1])-1]
+	var corners [4]Point
+	for i, pair := range [4][2]HoughLine{{a0, b0}, {a0, b1}, {a1, b1}, {a1, b0}} {
+		p, ok := pair[0].Intersect(pair[1])
+		if !ok {
+			return q, 0, 0, ErrNoLineFamilies
+		}
+		corners[i] = p
+	}
+	return orderCorners(corners), len(runs[0]), len(runs[1]), nil
+}
+
+// cross returns the cross product of a and b, for homogeneous points and lines
+// the line through two points or the point where two lines meet.
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// smallestEigenvector returns the unit eigenvector of the smallest eigenvalue of
+// the symmetric n×n matrix m, by Jacobi rotations. m is overwritten.
+func smallestEigenvector(m [][]float64) []float64 {
+	n := len(m)
+	v := make([][]float64, n)
+	for i := range v {
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+	}
+	for sweep := 0; sweep < 50; sweep++ {
+		off, diag := 0.0, 0.0
+		for p := 0; p < n; p++ {
+			diag += math.Abs(m[p][p])
+			for q := p + 1; q < n; q++ {
+				off += math.Abs(m[p][q])
+			}
+		}
+		if off <= 1e-15*math.Max(1, diag) {
+			break
+		}
+		for p := 0; p < n-1; p++ {
+			for q := p + 1; q < n; q++ {
+				if m[p][q] == 0 {
+					continue
+				}
+				theta := (m[q][q] - m[p][p]) / (2 * m[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p], m[k][q] = c*mkp-s*mkq, s*mkp+c*mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k], m[q][k] = c*mpk-s*mqk, s*mpk+c*mqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p], v[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+	best := 0
+	for i := 1; i < n; i++ {
+		if m[i][i] < m[best][best] {
+			best = i
+		}
+	}
+	out := make([]float64, n)
+	for k := range out {
+		out[k] = v[k][best]
+	}
+	return out
+}
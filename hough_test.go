@@ -0,0 +1,112 @@
+package gobancrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// angledBoard renders a grey 19×19 board onto a dark table at the corners of
+// quad, and returns the image with the homography from board pixels to image pixels.
+func angledBoard(quad Quadrilateral) (*image.NRGBA, Homography) {
+	board := syntheticBoard(19, 20, 20)
+	for i := 0; i < len(board.Pix); i += 4 {
+		// No wood colour to go by
+		g := uint8((int(board.Pix[i]) + int(board.Pix[i+1]) + int(board.Pix[i+2])) / 3)
+		board.Pix[i], board.Pix[i+1], board.Pix[i+2] = g, g, g
+	}
+	return photographed(board, quad)
+}
+
+// photographed renders board onto a dark table at the corners of quad, and
+// returns the image with the homography from board pixels to image pixels.
+func photographed(board *image.NRGBA, quad Quadrilateral) (*image.NRGBA, Homography) {
+	side := float64(board.Bounds().Dx() - 1)
+	h, _ := NewHomography(Quadrilateral{{0, 0}, {side, 0}, {side, side}, {0, side}}, quad)
+	inv, _ := h.Inverse()
+	img := image.NewNRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			// Four samples a pixel, so the board edges are smooth like in a photo
+			var sum [3]uint32
+			for _, o := range [4]Point{{-0.25, -0.25}, {0.25, -0.25}, {-0.25, 0.25}, {0.25, 0.25}} {
+				p := inv.Apply(Point{float64(x) + o.X, float64(y) + o.Y})
+				c := color.Color(color.NRGBA{40, 50, 70, 255})
+				if p.X >= 0 && p.Y >= 0 && p.X <= side && p.Y <= side {
+					c = sampleBilinear(board, p)
+				}
+				r, g, b, _ := c.RGBA()
+				sum[0], sum[1], sum[2] = sum[0]+r>>8, sum[1]+g>>8, sum[2]+b>>8
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(sum[0] / 4), uint8(sum[1] / 4), uint8(sum[2] / 4), 255})
+		}
+	}
+	return img, h
+}
+
+func TestLineFamilies(t *testing.T) {
+	// A board in a photo taken from the side, and one taken from near the table,
+	// whose columns spread over more than 40°
+	for _, quad := range []Quadrilateral{{{150, 60}, {500, 100}, {570, 430}, {80, 400}}, {{200, 80}, {440, 80}, {600, 440}, {40, 440}}} {
+		img, h := angledBoard(quad)
+		fams, err := LineFamilies(img)
+		if err != nil {
+			t.Fatalf("%v: LineFamilies: %v", quad, err)
+		}
+		// The board's rows run towards the image of the direction (1,0), its columns towards (0,1)
+		want := [2]Point{{h[0] / h[6], h[3] / h[6]}, {h[1] / h[7], h[4] / h[7]}}
+		for i, f := range fams {
+			if len(f.Lines) < 19 || len(f.Lines) > 23 {
+				t.Errorf("%v: family %d: %d lines, want 19 and the board edges", quad, i, len(f.Lines))
+			}
+			vp, ok := f.VanishingPoint()
+			level := math.Abs(h[6]) < 1e-9
+			if !ok && !level {
+				t.Fatalf("%v: family %d: lines are parallel", quad, i)
+			}
+			// Either family may be the rows, the vanishing point should be one of them.
+			// Rows that are level in the image meet far away to the side.
+			ok = !ok || level && math.Abs(vp.Y-240) < math.Abs(vp.X-320)/100
+			for _, w := range want {
+				ok = ok || dist(vp, w) < dist(w, Point{320, 240})/20
+			}
+			if !ok {
+				t.Errorf("%v: family %d: vanishing point %v, want one of %v", quad, i, vp, want)
+			}
+		}
+	}
+}
+
+func TestFindGobanLines(t *testing.T) {
+	img, h := angledBoard(Quadrilateral{{150, 60}, {500, 100}, {570, 430}, {80, 400}})
+	if _, err := FindGoban(img); err == nil {
+		t.Fatal("FindGoban found wood on a grey board")
+	}
+	res, err := Crop(img, nil)
+	if err != nil {
+		t.Fatalf("Crop: %v", err)
+	}
+	if res.Fallback || res.BoardSize != 19 {
+		t.Fatalf("found a %dx%d grid, fallback %v", res.BoardSize, res.BoardSize, res.Fallback)
+	}
+	// The wood can not count against a grey board
+	if res.Confidence < 0.7 || !math.IsNaN(res.Grid.Confidence.Wood) {
+		t.Errorf("confidence %+v", res.Grid.Confidence)
+	}
+	lo, hi := 20.0, 20.0+18*20
+	for i, c := range [4]Point{{lo, lo}, {hi, lo}, {hi, hi}, {lo, hi}} {
+		if want := h.Apply(c); dist(res.Refined[i], want) > 3 {
+			t.Errorf("corner %d at %v, want %v", i, res.Refined[i], want)
+		}
+	}
+}
+
+func TestSmallestEigenvector(t *testing.T) {
+	// Eigenvalues 1, 2 and 4, the smallest along (1,1,0)/√2
+	m := [][]float64{{1.5, -0.5, 0}, {-0.5, 1.5, 0}, {0, 0, 4}}
+	v := smallestEigenvector(m)
+	if math.Abs(math.Abs(v[0])-math.Sqrt2/2) > 1e-9 || math.Abs(v[0]-v[1]) > 1e-9 || math.Abs(v[2]) > 1e-9 {
+		t.Errorf("eigenvector %v, want ±(1,1,0)/√2", v)
+	}
+}
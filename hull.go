@@ -0,0 +1,122 @@
+package gobancrop
+
+import (
+	"math"
+	"sort"
+)
+
+// convexHull returns the convex hull of pts in counter-clockwise order (Andrew's monotone chain).
+func convexHull(pts []Point) []Point {
+	if len(pts) < 3 {
+		return append([]Point(nil), pts...)
+	}
+	ps := append([]Point(nil), pts...)
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].X != ps[j].X {
+			return ps[i].X < ps[j].X
+		}
+		return ps[i].Y < ps[j].Y
+	})
+	cross := func(o, a, b Point) float64 {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+	hull := make([]Point, 0, 2*len(ps))
+	for _, p := range ps {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(ps) - 2; i >= 0; i-- {
+		p := ps[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
+
+// approxQuad reduces a convex polygon to four corners by repeatedly dropping the
+// vertex whose removal loses the least area, then moves each corner to where the
+// lines through the longest polygon edge on either side meet. The corners are
+// returned in top-left, top-right, bottom-right, bottom-left order.
+func approxQuad(poly []Point) (Quadrilateral, bool) {
+	if len(poly) < 4 {
+		return Quadrilateral{}, false
+	}
+	idx := make([]int, len(poly))
+	for i := range idx {
+		idx[i] = i
+	}
+	for len(idx) > 4 {
+		minI, minA := 0, math.Inf(1)
+		for i := range idx {
+			a, b, c := poly[idx[(i+len(idx)-1)%len(idx)]], poly[idx[i]], poly[idx[(i+1)%len(idx)]]
+			if area := math.Abs((b.X-a.X)*(c.Y-a.Y)-(b.Y-a.Y)*(c.X-a.X)) / 2; area < minA {
+				minI, minA = i, area
+			}
+		}
+		idx = append(idx[:minI], idx[minI+1:]...)
+	}
+	// The longest polygon edge between two corners is the best estimate of that side
+	var sides [4][2]Point
+	for k := range sides {
+		from, to := idx[k], idx[(k+1)%4]
+		best := -1.0
+		for j := from; j != to; j = (j + 1) % len(poly) {
+			a, b := poly[j], poly[(j+1)%len(poly)]
+			if l := dist(a, b); l > best {
+				best, sides[k] = l, [2]Point{a, b}
+			}
+		}
+	}
+	var corners [4]Point
+	for k := range corners {
+		c, prev, next := poly[idx[k]], poly[idx[(k+3)%4]], poly[idx[(k+1)%4]]
+		// Nearly parallel sides can meet far away, so only allow a small move
+		limit := 0.1 * math.Min(dist(c, prev), dist(c, next))
+		corners[k] = c
+		if p, ok := intersectLines(sides[(k+3)%4], sides[k]); ok && dist(p, c) <= limit {
+			corners[k] = p
+		}
+	}
+	return orderCorners(corners), true
+}
+
+// intersectLines returns the intersection of the infinite lines through a and b.
+func intersectLines(a, b [2]Point) (Point, bool) {
+	d1x, d1y := a[1].X-a[0].X, a[1].Y-a[0].Y
+	d2x, d2y := b[1].X-b[0].X, b[1].Y-b[0].Y
+	den := d1x*d2y - d1y*d2x
+	if math.Abs(den) < 1e-9 {
+		return Point{}, false
+	}
+	t := ((b[0].X-a[0].X)*d2y - (b[0].Y-a[0].Y)*d2x) / den
+	return Point{a[0].X + t*d1x, a[0].Y + t*d1y}, true
+}
+
+// orderCorners sorts four corners into top-left, top-right, bottom-right, bottom-left order.
+func orderCorners(ps [4]Point) Quadrilateral {
+	var cx, cy float64
+	for _, p := range ps {
+		cx += p.X / 4
+		cy += p.Y / 4
+	}
+	// With y pointing down, increasing angle runs clockwise on screen
+	sort.Slice(ps[:], func(i, j int) bool {
+		return math.Atan2(ps[i].Y-cy, ps[i].X-cx) < math.Atan2(ps[j].Y-cy, ps[j].X-cx)
+	})
+	first := 0
+	for i, p := range ps {
+		if p.X+p.Y < ps[first].X+ps[first].Y {
+			first = i
+		}
+	}
+	var q Quadrilateral
+	for i := range q {
+		q[i] = ps[(first+i)%4]
+	}
+	return q
+}
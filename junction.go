@@ -0,0 +1,228 @@
+package gobancrop
+
+import (
+	"image"
+	"math"
+)
+
+// JunctionType is the shape the grid lines make at an intersection.
+type JunctionType uint8
+
+const (
+	NoJunction    JunctionType = iota // fewer than two lines meet, or they run straight through
+	LJunction                         // two lines end, at a corner of the board
+	TJunction                         // one line ends on another, along an edge of the board
+	CrossJunction                     // two lines cross, inside the board
+)
+
+func (t JunctionType) String() string {
+	switch t {
+	case LJunction:
+		return "L"
+	case TJunction:
+		return "T"
+	case CrossJunction:
+		return "cross"
+	}
+	return "none"
+}
+
+// Arm is a direction a line can leave an intersection in, along the lattice.
+type Arm uint8
+
+const (
+	ArmRight Arm = iota // towards the next column
+	ArmDown             // towards the next row
+	ArmLeft
+	ArmUp
+)
+
+// armSteps are the column and row steps of each Arm.
+var armSteps = [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+
+// Junction is an intersection of a lattice, with the lines seen leaving it.
+type Junction struct {
+	Col, Row int
+	Point    Point
+	Arms     [4]bool // by Arm
+	Type     JunctionType
+}
+
+// Junction looks at which lines leave the intersection at col, row in img and
+// classifies it. An arm must be about as dark as the darkest arm, so wood grain
+// and printing next to the board do not count as lines. Stones hide the lines
+// under them, so a covered intersection has no junction.
+func (l *Lattice) Junction(img *image.NRGBA, col, row int) Junction {
+	j := Junction{Col: col, Row: row, Point: l.Point(col, row)}
+	var depth [4]float64
+	darkest := 0.0
+	for a := range depth {
+		depth[a] = l.arm(img, col, row, Arm(a))
+		darkest = math.Max(darkest, depth[a])
+	}
+	n := 0
+	for a := range j.Arms {
+		j.Arms[a] = depth[a] > 0 && depth[a] >= darkest/2
+		if j.Arms[a] {
+			n++
+		}
+	}
+	switch {
+	case n == 4:
+		j.Type = CrossJunction
+	case n == 3:
+		j.Type = TJunction
+	case n == 2 && j.Arms[ArmRight] != j.Arms[ArmLeft]:
+		j.Type = LJunction
+	}
+	return j
+}
+
+// Junctions classifies every intersection of l in row-major order.
+func (l *Lattice) Junctions(img *image.NRGBA) []Junction {
+	js := make([]Junction, 0, l.Size*l.Size)
+	for row := 0; row < l.Size; row++ {
+		for col := 0; col < l.Size; col++ {
+			js = append(js, l.Junction(img, col, row))
+		}
+	}
+	return js
+}
+
+// arm returns how much darker than the wood next to it the line leaving the
+// intersection at col, row in direction a is, or 0 if there is none. The line
+// is looked for three times along the first cell, away from the intersections
+// at both ends, where the lattice puts it, and must be seen at least twice.
+func (l *Lattice) arm(img *image.NRGBA, col, row int, a Arm) float64 {
+	n := float64(l.Size - 1)
+	dc, dr := float64(armSteps[a][0]), float64(armSteps[a][1])
+	board := func(c, r float64) Point { return l.h.Apply(Point{c / n, r / n}) }
+	var depths []float64
+	for _, f := range []float64{0.3, 0.5, 0.7} {
+		c, r := float64(col)+f*dc, float64(row)+f*dr
+		p := board(c, r)
+		dir := sub(board(c+0.25*dc, r+0.25*dr), board(c-0.25*dc, r-0.25*dr))
+		length := math.Hypot(dir.X, dir.Y)
+		if length == 0 {
+			return 0
+		}
+		normal := Point{-dir.Y / length, dir.X / length}
+		// Across the arm is along the other direction of the lattice
+		cell := sub(board(c+math.Abs(dr), r+math.Abs(dc)), board(c-math.Abs(dr), r-math.Abs(dc)))
+		width := math.Abs(cell.X*normal.X+cell.Y*normal.Y) / 2
+		if t, depth, ok := lineValley(img, p, normal, fitWindow*width); ok && math.Abs(t) <= 0.1*width {
+			depths = append(depths, depth)
+		}
+	}
+	if len(depths) < 2 {
+		return 0
+	}
+	return median(depths)
+}
+
+// sideState is what the grid looks like beyond one of its outer lines.
+type sideState uint8
+
+const (
+	sideUnknown  sideState = iota // too little of the line was seen to tell
+	sideEdge                      // the lines end there, the edge of the board
+	sideOpen                      // the lines go on, the board is cut off or the grid is too small
+	sideDetached                  // the other lines do not reach it, a border or a row of labels
+)
+
+// sides returns the states of the top, right, bottom and left outer lines of l,
+// in the order of the corners of its quad, by the arms of the junctions along
+// each of them.
+func (l *Lattice) sides(img *image.NRGBA) [4]sideState {
+	last := l.Size - 1
+	var states [4]sideState
+	for s := range states {
+		out := [4]Arm{ArmUp, ArmRight, ArmDown, ArmLeft}[s]
+		in := (out + 2) % 4
+		seen, outward, inward := 0, 0, 0
+		for k := 1; k < last; k++ {
+			col, row := k, 0
+			switch out {
+			case ArmRight:
+				col, row = last, k
+			case ArmDown:
+				row = last
+			case ArmLeft:
+				col, row = 0, k
+			}
+			j := l.Junction(img, col, row)
+			along := j.Arms[(out+1)%4] || j.Arms[(out+3)%4]
+			if !along && !j.Arms[in] {
+				continue
+			}
+			seen++
+			if j.Arms[out] {
+				outward++
+			}
+			if j.Arms[in] {
+				inward++
+			}
+		}
+		switch {
+		case seen < max(3, (l.Size-2)/3):
+			states[s] = sideUnknown
+		case 2*outward >= seen:
+			states[s] = sideOpen
+		case 4*inward < seen:
+			states[s] = sideDetached
+		default:
+			states[s] = sideEdge
+		}
+	}
+	return states
+}
+
+// fixEdges moves the grid in quad by a line when it is one off: when its first
+// line is a border or labels that the other lines do not reach, or was not seen
+// at all, and the lines go on past its last line. rowRes and colRes are the
+// residuals of the lines of quad, as from FitGrid. It returns the quad and which
+// of its sides, top, right, bottom and left, were seen to be the edge of the board.
+func (d *Detector) fixEdges(img *image.NRGBA, quad Quadrilateral, size int, rowRes, colRes []float64) (Quadrilateral, [4]bool) {
+	var visible [4]bool
+	for try := 0; ; try++ {
+		l, err := NewLattice(quad, size)
+		if err != nil {
+			break
+		}
+		states := l.sides(img)
+		for s := range visible {
+			visible[s] = states[s] == sideEdge
+		}
+		unseen := [4]bool{outerUnseen(rowRes, 0), outerUnseen(colRes, size-1), outerUnseen(rowRes, size-1), outerUnseen(colRes, 0)}
+		var shift Point
+		for s, st := range states {
+			opposite := states[(s+2)%4]
+			if (st != sideDetached && !unseen[s]) || opposite != sideOpen {
+				continue
+			}
+			// Towards the opposite side by a cell
+			step := armSteps[[4]Arm{ArmDown, ArmLeft, ArmUp, ArmRight}[s]]
+			shift = Point{shift.X + float64(step[0]), shift.Y + float64(step[1])}
+		}
+		if shift == (Point{}) || try == 2 {
+			break
+		}
+		n := float64(size - 1)
+		var moved Quadrilateral
+		for i, c := range unitSquare {
+			moved[i] = l.h.Apply(Point{c.X + shift.X/n, c.Y + shift.Y/n})
+		}
+		d.log().Debug("FindActualBoard: grid is a line off", "size", size, "sides", states, "shift", shift)
+		quad, rowRes, colRes = moved, nil, nil
+		if fit, fr, fc, err := FitGrid(img, moved, size); err == nil {
+			quad, rowRes, colRes = fit, fr, fc
+		}
+	}
+	return quad, visible
+}
+
+// outerUnseen reports whether the line k of residuals, as from FitGrid, was not
+// seen in the image.
+func outerUnseen(residuals []float64, k int) bool {
+	return k < len(residuals) && math.IsNaN(residuals[k])
+}
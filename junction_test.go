@@ -0,0 +1,106 @@
+package gobancrop
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestJunctions(t *testing.T) {
+	img := syntheticBoard(19, 20, 40)
+	l, err := NewLattice(syntheticQuad(19, 20, 40), 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range l.Junctions(img) {
+		onCol, onRow := j.Col == 0 || j.Col == 18, j.Row == 0 || j.Row == 18
+		want := CrossJunction
+		switch {
+		case onCol && onRow:
+			want = LJunction
+		case onCol || onRow:
+			want = TJunction
+		}
+		if j.Type != want {
+			t.Errorf("(%d,%d): %v with arms %v, want %v", j.Col, j.Row, j.Type, j.Arms, want)
+		}
+	}
+	if j := l.Junction(img, 18, 0); j.Arms != [4]bool{false, true, true, false} {
+		t.Errorf("top right corner arms %v, want down and left", j.Arms)
+	}
+
+	// A 13×13 grid in the top left of the board sees the edge at the top and left only
+	part, _ := NewLattice(syntheticQuad(13, 20, 40), 13)
+	if s := part.sides(img); s != [4]sideState{sideEdge, sideOpen, sideOpen, sideEdge} {
+		t.Errorf("sides of a part of the board %v", s)
+	}
+}
+
+func TestFixEdges(t *testing.T) {
+	// A border line a cell left of the board, which a grid one line off takes for its first column
+	img := syntheticBoard(19, 20, 40)
+	for y := 40; y <= 400; y++ {
+		img.SetNRGBA(20, y, color.NRGBA{20, 20, 20, 255})
+	}
+	off := Quadrilateral{{20, 40}, {380, 40}, {380, 400}, {20, 400}}
+	l, _ := NewLattice(off, 19)
+	if s := l.sides(img); s[3] != sideDetached || s[1] != sideOpen {
+		t.Errorf("sides of the grid one off %v, want the left detached and the right open", s)
+	}
+	quad, visible := NewDetector().fixEdges(img, off, 19, nil, nil)
+	want := syntheticQuad(19, 20, 40)
+	for i := range quad {
+		if dist(quad[i], want[i]) > 0.5 {
+			t.Errorf("corner %d at %v, want %v", i, quad[i], want[i])
+		}
+	}
+	if visible != [4]bool{true, true, true, true} {
+		t.Errorf("visible sides %v, want all", visible)
+	}
+
+	// A coarse quad that cuts off the last column leaves only the grid one off
+	// in the warp. Once moved, the fourth column, erased here, is the one unseen.
+	for y := 40; y <= 400; y++ {
+		for x := 97; x <= 103; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{220, 177, 113, 255})
+		}
+	}
+	g, err := FindActualBoard(img, Quadrilateral{{10, 30}, {390, 30}, {390, 410}, {10, 410}}, 19)
+	if err != nil {
+		t.Fatalf("FindActualBoard: %v", err)
+	}
+	for i := range g.Quad {
+		if dist(g.Quad[i], want[i]) > 0.5 {
+			t.Errorf("FindActualBoard corner %d at %v, want %v", i, g.Quad[i], want[i])
+		}
+	}
+	for k, r := range g.ColResiduals {
+		if math.IsNaN(r) != (k == 3) {
+			t.Errorf("column %d: residual %.3f", k, r)
+		}
+	}
+	if g.Confidence.Overall < 0.7 {
+		t.Errorf("confidence %+v", g.Confidence)
+	}
+
+	// Under a strong keystone the edge of the board, which is no line, can take
+	// the place of the last row, and the first row is left out
+	img, h := photographed(syntheticBoard(19, 20, 20), Quadrilateral{{200, 80}, {440, 80}, {600, 440}, {40, 440}})
+	res, err := Crop(img, nil)
+	if err != nil {
+		t.Fatalf("Crop of a keystoned board: %v", err)
+	}
+	for i, c := range unitSquare {
+		if p := h.Apply(Point{20 + 360*c.X, 20 + 360*c.Y}); dist(res.Refined[i], p) > 2 {
+			t.Errorf("keystoned corner %d at %v, want %v", i, res.Refined[i], p)
+		}
+	}
+	if res.Grid.Visible != [4]bool{true, true, true, true} {
+		t.Errorf("keystoned visible sides %v, want all", res.Grid.Visible)
+	}
+	for _, r := range [][]float64{res.Grid.RowResiduals, res.Grid.ColResiduals} {
+		if math.IsNaN(r[0]) || math.IsNaN(r[18]) {
+			t.Errorf("keystoned outer lines not seen: %v", r)
+		}
+	}
+}
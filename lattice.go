@@ -0,0 +1,86 @@
+package gobancrop
+
+import "math"
+
+// Lattice maps between board coordinates and source image coordinates for an
+// N×N grid whose outermost lines run along the edges of Quad.
+type Lattice struct {
+	Quad Quadrilateral
+	Size int
+	h    Homography
+	inv  Homography
+}
+
+// NewLattice builds the lattice for a refined board quadrilateral and board size.
+func NewLattice(quad Quadrilateral, size int) (*Lattice, error) {
+	if size < 2 {
+		return nil, ErrInvalidBoardSize
+	}
+	h, err := SquareToQuad(quad)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := h.Inverse()
+	if err != nil {
+		return nil, err
+	}
+	return &Lattice{Quad: quad, Size: size, h: h, inv: inv}, nil
+}
+
+// Point returns the image position of the intersection at column col and row row, both zero-based.
+func (l *Lattice) Point(col, row int) Point {
+	n := float64(l.Size - 1)
+	return l.h.Apply(Point{float64(col) / n, float64(row) / n})
+}
+
+// Points returns all Size×Size intersections in row-major order.
+func (l *Lattice) Points() []Point {
+	pts := make([]Point, 0, l.Size*l.Size)
+	for row := 0; row < l.Size; row++ {
+		for col := 0; col < l.Size; col++ {
+			pts = append(pts, l.Point(col, row))
+		}
+	}
+	return pts
+}
+
+// Nearest returns the intersection closest to the image point p and its distance in pixels.
+func (l *Lattice) Nearest(p Point) (col, row int, off float64) {
+	n := float64(l.Size - 1)
+	b := l.inv.Apply(p)
+	c0 := clampInt(int(math.Round(b.X*n)), 0, l.Size-1)
+	r0 := clampInt(int(math.Round(b.Y*n)), 0, l.Size-1)
+	// Perspective can move the closest image-space point one step away from the rounded one
+	off = math.Inf(1)
+	for r := r0 - 1; r <= r0+1; r++ {
+		for c := c0 - 1; c <= c0+1; c++ {
+			if r < 0 || c < 0 || r >= l.Size || c >= l.Size {
+				continue
+			}
+			q := l.Point(c, r)
+			if d := dist(q, p); d < off {
+				col, row, off = c, r, d
+			}
+		}
+	}
+	return
+}
+
+// Intersections returns all size×size intersections of the board in quad, in source image coordinates.
+func Intersections(quad Quadrilateral, size int) ([]Point, error) {
+	l, err := NewLattice(quad, size)
+	if err != nil {
+		return nil, err
+	}
+	return l.Points(), nil
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
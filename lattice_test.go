@@ -0,0 +1,26 @@
+package gobancrop
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLatticeNearest(t *testing.T) {
+	quad := Quadrilateral{{100, 100}, {460, 90}, {500, 470}, {80, 450}}
+	l, err := NewLattice(quad, 19)
+	if err != nil {
+		t.Fatalf("NewLattice: %v", err)
+	}
+	pts := l.Points()
+	if len(pts) != 19*19 {
+		t.Fatalf("got %d points, want %d", len(pts), 19*19)
+	}
+	if dist(pts[0], quad[0]) > 1e-6 || dist(pts[len(pts)-1], quad[2]) > 1e-6 {
+		t.Errorf("corner intersections %v %v do not match quad", pts[0], pts[len(pts)-1])
+	}
+	p := l.Point(7, 11)
+	col, row, dist := l.Nearest(Point{p.X + 2, p.Y - 1})
+	if col != 7 || row != 11 || math.Abs(dist-math.Sqrt(5)) > 1e-6 {
+		t.Errorf("Nearest = (%d,%d,%.3f), want (7,11,%.3f)", col, row, dist, math.Sqrt(5))
+	}
+}
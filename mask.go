@@ -0,0 +1,270 @@
+package gobancrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Region is a connected part of the wood mask that may be a board.
+type Region struct {
+	Bounds image.Rectangle
+	Area   int           // number of pixels in the region
+	Quad   Quadrilateral // quadrilateral fitted to the outline
+	Score  float64       // how likely the region is a board, in [0,1]
+	Mask   *image.Alpha  // the region's pixels, covering Bounds
+
+	hull     []Point
+	evidence float64 // share of the region that looks like grid lines, in [0,1]
+}
+
+// WoodMask returns the pixels of img that have the colour of a wooden board.
+func WoodMask(img *image.NRGBA) *image.Alpha {
+	return NewDetector().WoodMask(img)
+}
+
+// WoodMask is like the package level WoodMask, with the wood colour of d.
+func (d *Detector) WoodMask(img *image.NRGBA) *image.Alpha {
+	b := img.Bounds()
+	m := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if d.isWood(img.At(x, y)) {
+				m.SetAlpha(x, y, color.Alpha{255})
+			}
+		}
+	}
+	return m
+}
+
+// BoardMask returns the mask of the region most likely to be the board.
+func BoardMask(img *image.NRGBA) (*image.Alpha, error) {
+	return NewDetector().BoardMask(img)
+}
+
+// BoardMask is like the package level BoardMask, with the wood colour of d.
+func (d *Detector) BoardMask(img *image.NRGBA) (*image.Alpha, error) {
+	regions := d.WoodRegions(img)
+	if len(regions) == 0 {
+		return nil, ErrNoBoardRegion
+	}
+	full := image.NewAlpha(img.Bounds())
+	r := regions[0]
+	for y := r.Bounds.Min.Y; y < r.Bounds.Max.Y; y++ {
+		copy(full.Pix[full.PixOffset(r.Bounds.Min.X, y):], r.Mask.Pix[r.Mask.PixOffset(r.Bounds.Min.X, y):r.Mask.PixOffset(r.Bounds.Max.X, y)])
+	}
+	return full, nil
+}
+
+// WoodRegions cleans up the wood mask with a morphological open and close, labels
+// its connected components and returns those big enough to be a board, best first.
+// Regions are scored by area, squareness and how much grid the close filled in.
+func WoodRegions(img *image.NRGBA) []Region {
+	return NewDetector().WoodRegions(img)
+}
+
+// WoodRegions is like the package level WoodRegions, with the wood colour of d.
+func (d *Detector) WoodRegions(img *image.NRGBA) []Region {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil
+	}
+	raw := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			raw[y*w+x] = d.isWood(img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	// Opening drops specks, closing bridges the grid lines so the board is one piece
+	r := max(2, min(w, h)/200)
+	mask := morph(morph(raw, w, h, 1, false), w, h, 1, true)
+	mask = morph(morph(mask, w, h, r, true), w, h, r, false)
+	d.stage("wood-raw", func() image.Image { return boolImage(raw, w, h) })
+	d.stage("wood", func() image.Image { return boolImage(mask, w, h) })
+
+	minArea := max(100, w*h/500)
+	labels := make([]int32, w*h)
+	var regions []Region
+	var queue []int
+	var id int32
+	for start := range mask {
+		if !mask[start] || labels[start] != 0 {
+			continue
+		}
+		id++
+		labels[start] = id
+		queue = append(queue[:0], start)
+		for i := 0; i < len(queue); i++ {
+			x, y := queue[i]%w, queue[i]/w
+			for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if n[0] < 0 || n[1] < 0 || n[0] >= w || n[1] >= h {
+					continue
+				}
+				if j := n[1]*w + n[0]; mask[j] && labels[j] == 0 {
+					labels[j] = id
+					queue = append(queue, j)
+				}
+			}
+		}
+		if len(queue) >= minArea {
+			regions = append(regions, newRegion(queue, labels, raw, w, h, b.Min))
+		}
+	}
+	regions = mergeRegions(regions)
+	sortRegions(regions)
+	return regions
+}
+
+// newRegion collects the pixels in idx into a Region.
+func newRegion(idx []int, labels []int32, raw []bool, w, h int, off image.Point) Region {
+	id := labels[idx[0]]
+	minX, minY, maxX, maxY := w, h, 0, 0
+	for _, i := range idx {
+		x, y := i%w, i/w
+		minX, minY = min(minX, x), min(minY, y)
+		maxX, maxY = max(maxX, x), max(maxY, y)
+	}
+	bounds := image.Rect(minX, minY, maxX+1, maxY+1).Add(off)
+	reg := Region{Bounds: bounds, Area: len(idx), Mask: image.NewAlpha(bounds)}
+	inside := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < w && y < h && labels[y*w+x] == id
+	}
+	var outline []Point
+	filled := 0
+	for _, i := range idx {
+		x, y := i%w, i/w
+		reg.Mask.SetAlpha(off.X+x, off.Y+y, color.Alpha{255})
+		if !raw[i] {
+			filled++
+		}
+		if !inside(x-1, y) || !inside(x+1, y) || !inside(x, y-1) || !inside(x, y+1) {
+			outline = append(outline, Point{float64(off.X + x), float64(off.Y + y)})
+		}
+	}
+	reg.hull = convexHull(outline)
+	reg.Quad, _ = approxQuad(reg.hull)
+	// Grid lines are thin non-wood runs that the close fills in, a good part of any board
+	reg.evidence = math.Min(1, float64(filled)/float64(len(idx))/0.05)
+	return reg
+}
+
+// mergeRegions folds regions whose centre lies inside a larger region's hull into it,
+// since walls of stones can cut a board into several pieces.
+func mergeRegions(regions []Region) []Region {
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Area > regions[j].Area })
+	var out []Region
+	for _, reg := range regions {
+		c := Point{float64(reg.Bounds.Min.X+reg.Bounds.Max.X) / 2, float64(reg.Bounds.Min.Y+reg.Bounds.Max.Y) / 2}
+		merged := false
+		for i := range out {
+			if !insideConvex(out[i].hull, c) {
+				continue
+			}
+			o := &out[i]
+			bounds := o.Bounds.Union(reg.Bounds)
+			m := image.NewAlpha(bounds)
+			for _, src := range []*image.Alpha{o.Mask, reg.Mask} {
+				sb := src.Bounds()
+				for y := sb.Min.Y; y < sb.Max.Y; y++ {
+					for x := sb.Min.X; x < sb.Max.X; x++ {
+						if src.AlphaAt(x, y).A != 0 {
+							m.SetAlpha(x, y, color.Alpha{255})
+						}
+					}
+				}
+			}
+			o.evidence = (o.evidence*float64(o.Area) + reg.evidence*float64(reg.Area)) / float64(o.Area+reg.Area)
+			o.Bounds, o.Mask, o.Area = bounds, m, o.Area+reg.Area
+			o.hull = convexHull(append(o.hull, reg.hull...))
+			o.Quad, _ = approxQuad(o.hull)
+			merged = true
+			break
+		}
+		if !merged {
+			out = append(out, reg)
+		}
+	}
+	return out
+}
+
+// sortRegions scores the regions and sorts them best first.
+func sortRegions(regions []Region) {
+	maxArea := 0
+	for _, reg := range regions {
+		maxArea = max(maxArea, reg.Area)
+	}
+	for i := range regions {
+		reg := &regions[i]
+		area := math.Sqrt(float64(reg.Area) / float64(maxArea))
+		reg.Score = area * quadSquareness(reg.Quad) * (0.5 + 0.5*reg.evidence)
+	}
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].Score > regions[j].Score })
+}
+
+// quadSquareness compares the average widths and heights of q, 1 for a square.
+func quadSquareness(q Quadrilateral) float64 {
+	w := (dist(q[0], q[1]) + dist(q[3], q[2])) / 2
+	h := (dist(q[0], q[3]) + dist(q[1], q[2])) / 2
+	if w == 0 || h == 0 {
+		return 0
+	}
+	return math.Min(w, h) / math.Max(w, h)
+}
+
+// insideConvex reports if p lies inside the convex polygon poly.
+func insideConvex(poly []Point, p Point) bool {
+	if len(poly) < 3 {
+		return false
+	}
+	sign := 0.0
+	for i := range poly {
+		a, b := poly[i], poly[(i+1)%len(poly)]
+		c := (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+		if c == 0 {
+			continue
+		}
+		if sign == 0 {
+			sign = c
+		} else if (c > 0) != (sign > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// morph erodes or dilates a w×h mask with a (2r+1)×(2r+1) square, one axis at a time.
+func morph(src []bool, w, h, r int, dilate bool) []bool {
+	tmp := make([]bool, len(src))
+	dst := make([]bool, len(src))
+	pass := func(in, out []bool, n, stride, lines, lineStride int) {
+		for l := 0; l < lines; l++ {
+			base := l * lineStride
+			// count holds the number of set pixels in the window around i
+			count := 0
+			for i := 0; i < min(r, n); i++ {
+				if in[base+i*stride] {
+					count++
+				}
+			}
+			for i := 0; i < n; i++ {
+				if j := i + r; j < n && in[base+j*stride] {
+					count++
+				}
+				if j := i - r - 1; j >= 0 && in[base+j*stride] {
+					count--
+				}
+				lo, hi := max(0, i-r), min(n-1, i+r)
+				if dilate {
+					out[base+i*stride] = count > 0
+				} else {
+					out[base+i*stride] = count == hi-lo+1
+				}
+			}
+		}
+	}
+	pass(src, tmp, w, 1, h, w)
+	pass(tmp, dst, h, w, w, 1)
+	return dst
+}
@@ -0,0 +1,111 @@
+package gobancrop
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Colours used by Overlay.
+var (
+	OverlayWoodColor         = color.NRGBA{0, 200, 0, 255}
+	OverlayCoarseColor       = color.NRGBA{0, 120, 255, 255}
+	OverlayRefinedColor      = color.NRGBA{255, 0, 0, 255}
+	OverlayLineColor         = color.NRGBA{255, 230, 0, 255}
+	OverlayInferredLineColor = color.NRGBA{255, 140, 0, 255}
+	OverlayIntersectionColor = color.NRGBA{255, 0, 255, 255}
+)
+
+// Overlay draws what Crop found onto a copy of img: the wood mask tinted green,
+// the coarse quad in blue, the refined quad in red, the grid lines in yellow
+// (orange where a line was not seen and was inferred) and the intersections in magenta.
+// res may be nil, as when Crop failed, to see just the wood.
+func Overlay(img *image.NRGBA, res *Result) *image.NRGBA {
+	return NewDetector().Overlay(img, res)
+}
+
+// Overlay is like the package level Overlay, with the wood colour of d.
+func (d *Detector) Overlay(img *image.NRGBA, res *Result) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+
+	mask := d.WoodMask(img)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if mask.AlphaAt(x, y).A != 0 {
+				blend(out, x, y, OverlayWoodColor, 0.35)
+			}
+		}
+	}
+
+	if res == nil {
+		return out
+	}
+	// Thicker strokes on bigger images, so they stay visible when scaled down
+	thick := max(1, min(b.Dx(), b.Dy())/400)
+	drawQuad(out, res.Coarse, OverlayCoarseColor, thick)
+	if res.Grid != nil {
+		if l, err := res.Grid.Lattice(); err == nil {
+			last := l.Size - 1
+			for k := 1; k < last; k++ {
+				c := OverlayLineColor
+				if k < len(res.Grid.RowResiduals) && math.IsNaN(res.Grid.RowResiduals[k]) {
+					c = OverlayInferredLineColor
+				}
+				drawLine(out, l.Point(0, k), l.Point(last, k), c, thick)
+				c = OverlayLineColor
+				if k < len(res.Grid.ColResiduals) && math.IsNaN(res.Grid.ColResiduals[k]) {
+					c = OverlayInferredLineColor
+				}
+				drawLine(out, l.Point(k, 0), l.Point(k, last), c, thick)
+			}
+			for _, p := range l.Points() {
+				fillSquare(out, p, thick, OverlayIntersectionColor)
+			}
+		}
+	}
+	drawQuad(out, res.Refined, OverlayRefinedColor, thick)
+	return out
+}
+
+// blend mixes c into the pixel at x, y with the given opacity.
+func blend(img *image.NRGBA, x, y int, c color.NRGBA, alpha float64) {
+	p := img.NRGBAAt(x, y)
+	mix := func(a, b uint8) uint8 { return uint8(float64(a)*(1-alpha) + float64(b)*alpha + 0.5) }
+	img.SetNRGBA(x, y, color.NRGBA{mix(p.R, c.R), mix(p.G, c.G), mix(p.B, c.B), p.A})
+}
+
+func drawQuad(img *image.NRGBA, q Quadrilateral, c color.NRGBA, thick int) {
+	for i := range q {
+		drawLine(img, q[i], q[(i+1)%4], c, thick)
+	}
+}
+
+// drawLine draws a line from a to b, thick pixels wide.
+func drawLine(img *image.NRGBA, a, b Point, c color.NRGBA, thick int) {
+	steps := int(math.Ceil(math.Max(math.Abs(b.X-a.X), math.Abs(b.Y-a.Y))))
+	if steps > 1<<16 {
+		return // an endpoint at infinity
+	}
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		fillSquare(img, Point{a.X + t*(b.X-a.X), a.Y + t*(b.Y-a.Y)}, thick/2, c)
+	}
+}
+
+// fillSquare fills the square of pixels within r of p.
+func fillSquare(img *image.NRGBA, p Point, r int, c color.NRGBA) {
+	cx, cy := int(math.Round(p.X)), int(math.Round(p.Y))
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			if (image.Point{x, y}).In(img.Bounds()) {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
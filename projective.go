@@ -0,0 +1,279 @@
+package gobancrop
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+const (
+	fitPasses   = 3    // rounds of looking for the lines and refitting
+	fitWindow   = 0.3  // how far across a line to look for it, in cells
+	fitContrast = 12.0 // how much darker than both sides a line must be
+)
+
+// lineSample is a point where a grid line was seen, with the board coordinate
+// across the line that it should map to.
+type lineSample struct {
+	p    Point
+	at   float64 // u for a column, v for a row, in [0, 1]
+	rows bool    // on a row, so at is v
+}
+
+// FitGrid fits the lines of a size×size grid, whose outer lines run roughly
+// along quad, in the coordinates of img. The lines are fitted as the projective
+// family the board makes under perspective, so their spacing follows the
+// cross-ratios of evenly spaced lines instead of being even in a warp of quad,
+// and a quad that is off by part of a cell does not skew the grid. Returns the
+// outer lines of the fit, and how far from each fitted row and column the line
+// was seen, in cell widths, NaN where it was not seen.
+func FitGrid(img *image.NRGBA, quad Quadrilateral, size int) (fit Quadrilateral, rowRes, colRes []float64, err error) {
+	if size < 2 {
+		return Quadrilateral{}, nil, nil, fmt.Errorf("%w: %d", ErrInvalidBoardSize, size)
+	}
+	h, err := SquareToQuad(quad)
+	if err != nil {
+		return Quadrilateral{}, nil, nil, err
+	}
+	var samples []lineSample
+	var g Homography
+	for pass := 0; pass < fitPasses; pass++ {
+		samples = gridSamples(img, h, size)
+		for trim := 0; trim < 2; trim++ {
+			if !spansGrid(samples, size) {
+				return Quadrilateral{}, nil, nil, fmt.Errorf("%w: too few lines seen in the image", ErrGridNotFound)
+			}
+			var ok bool
+			if g, ok = fitHomography(samples); !ok {
+				return Quadrilateral{}, nil, nil, ErrDegenerateQuad
+			}
+			if h, err = g.Inverse(); err != nil {
+				return Quadrilateral{}, nil, nil, ErrDegenerateQuad
+			}
+			samples = inliers(samples, g, size)
+		}
+	}
+	for i, c := range unitSquare {
+		fit[i] = h.Apply(c)
+	}
+	// The lines are only looked for within part of a cell, so the fit cannot move further
+	for i, c := range unitSquare {
+		inward := Point{c.X + (1-2*c.X)/float64(size-1), c.Y}
+		cell := dist(h.Apply(c), h.Apply(inward))
+		if dist(fit[i], quad[i]) > cell {
+			return Quadrilateral{}, nil, nil, fmt.Errorf("%w: the fit moved corner %d by %.1f pixels", ErrGridNotFound, i, dist(fit[i], quad[i]))
+		}
+	}
+	rowRes, colRes = lineResiduals(samples, g, size)
+	return fit, rowRes, colRes, nil
+}
+
+// gridSamples looks for each line of the grid that h maps from the unit square
+// onto img, a quarter, half and three quarters of the way along every cell. A
+// line is where the brightness across it is lowest, if both sides are clearly
+// brighter, which also skips the lines under stones and where lines cross.
+func gridSamples(img *image.NRGBA, h Homography, size int) []lineSample {
+	n := float64(size - 1)
+	var samples []lineSample
+	for _, rows := range []bool{true, false} {
+		board := func(along, across float64) Point {
+			if rows {
+				return Point{along, across}
+			}
+			return Point{across, along}
+		}
+		for k := 0; k < size; k++ {
+			across := float64(k) / n
+			for c := 0; c < size-1; c++ {
+				for _, f := range []float64{0.25, 0.5, 0.75} {
+					along := (float64(c) + f) / n
+					p := h.Apply(board(along, across))
+					dir := sub(h.Apply(board(along+0.25/n, across)), h.Apply(board(along-0.25/n, across)))
+					if l := math.Hypot(dir.X, dir.Y); l > 0 {
+						dir = Point{dir.X / l, dir.Y / l}
+					} else {
+						continue
+					}
+					normal := Point{-dir.Y, dir.X}
+					cell := sub(h.Apply(board(along, across+1/n)), h.Apply(board(along, across-1/n)))
+					win := fitWindow * math.Abs(cell.X*normal.X+cell.Y*normal.Y) / 2
+					if t, _, ok := lineValley(img, p, normal, win); ok {
+						samples = append(samples, lineSample{Point{p.X + t*normal.X, p.Y + t*normal.Y}, across, rows})
+					}
+				}
+			}
+		}
+	}
+	return samples
+}
+
+// lineValley returns where, within win pixels of p along normal, img is darkest,
+// and how much darker than the brighter side nearest it, if that is a dark line
+// between two brighter sides.
+func lineValley(img *image.NRGBA, p, normal Point, win float64) (t, depth float64, ok bool) {
+	const step = 0.5
+	steps := int(win / step)
+	if steps < 2 {
+		return 0, 0, false
+	}
+	prof := make([]float64, 2*steps+1)
+	for i := range prof {
+		t := float64(i-steps) * step
+		prof[i] = lumAt(img, Point{p.X + t*normal.X, p.Y + t*normal.Y})
+	}
+	lo := 1
+	for i := 1; i < len(prof)-1; i++ {
+		if prof[i] < prof[lo] {
+			lo = i
+		}
+	}
+	left, right := prof[0], prof[len(prof)-1]
+	for _, v := range prof[:lo] {
+		left = math.Max(left, v)
+	}
+	for _, v := range prof[lo:] {
+		right = math.Max(right, v)
+	}
+	depth = math.Min(left, right) - prof[lo]
+	if prof[lo] > prof[lo-1] || prof[lo] > prof[lo+1] || depth < fitContrast {
+		return 0, 0, false
+	}
+	// The bottom of the parabola through the lowest three
+	off := 0.0
+	if den := prof[lo-1] - 2*prof[lo] + prof[lo+1]; den > 0 {
+		off = (prof[lo-1] - prof[lo+1]) / (2 * den)
+	}
+	return (float64(lo-steps) + off) * step, depth, true
+}
+
+// fitHomography returns the transform from image to board coordinates that puts
+// every sample closest to its line, in the least squares sense: the smallest
+// eigenvector of the normal equations of u·(g₆x+g₇y+g₈) = g₀x+g₁y+g₂ for
+// columns and the same with v and g₃..g₅ for rows. Image coordinates are
+// centred and scaled during the fit.
+func fitHomography(samples []lineSample) (Homography, bool) {
+	var cx, cy float64
+	for _, s := range samples {
+		cx, cy = cx+s.p.X, cy+s.p.Y
+	}
+	cx, cy = cx/float64(len(samples)), cy/float64(len(samples))
+	scale := 0.0
+	for _, s := range samples {
+		scale += dist(s.p, Point{cx, cy})
+	}
+	scale /= float64(len(samples))
+	if scale == 0 {
+		return Homography{}, false
+	}
+	m := make([][]float64, 9)
+	for i := range m {
+		m[i] = make([]float64, 9)
+	}
+	var a [9]float64
+	for _, s := range samples {
+		x, y := (s.p.X-cx)/scale, (s.p.Y-cy)/scale
+		a = [9]float64{}
+		off := 0
+		if s.rows {
+			off = 3
+		}
+		a[off], a[off+1], a[off+2] = x, y, 1
+		a[6], a[7], a[8] = -s.at*x, -s.at*y, -s.at
+		for i := range a {
+			for j := range a {
+				m[i][j] += a[i] * a[j]
+			}
+		}
+	}
+	v := smallestEigenvector(m)
+	var g Homography
+	copy(g[:], v)
+	// Undo the normalisation: g∘T, with T the centring and scaling
+	t := Homography{1 / scale, 0, -cx / scale, 0, 1 / scale, -cy / scale, 0, 0, 1}
+	g = g.Mul(t)
+	return g, g[8] != 0 || g[6] != 0 || g[7] != 0
+}
+
+// inliers drops the samples that are more than three times the median, or a
+// twentieth of a cell, away from their line under g.
+func inliers(samples []lineSample, g Homography, size int) []lineSample {
+	res := make([]float64, len(samples))
+	for i, s := range samples {
+		res[i] = offLine(s, g, size)
+	}
+	limit := math.Max(0.05, 3*median(append([]float64(nil), res...)))
+	var kept []lineSample
+	for i, s := range samples {
+		if res[i] <= limit {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// lineResiduals returns how far the samples of each row and column are from it
+// under g on average, in cells, or NaN for lines without samples.
+func lineResiduals(samples []lineSample, g Homography, size int) (rows, cols []float64) {
+	n := float64(size - 1)
+	rows, cols = make([]float64, size), make([]float64, size)
+	rowCnt, colCnt := make([]int, size), make([]int, size)
+	for _, s := range samples {
+		k := int(math.Round(s.at * n))
+		if s.rows {
+			rows[k] += offLine(s, g, size)
+			rowCnt[k]++
+		} else {
+			cols[k] += offLine(s, g, size)
+			colCnt[k]++
+		}
+	}
+	// Lines without samples are left at 0/0, NaN
+	for k := range rows {
+		rows[k] /= float64(rowCnt[k])
+		cols[k] /= float64(colCnt[k])
+	}
+	return rows, cols
+}
+
+// offLine returns how far s is from its line under g, in cells.
+func offLine(s lineSample, g Homography, size int) float64 {
+	b := g.Apply(s.p)
+	at := b.X
+	if s.rows {
+		at = b.Y
+	}
+	return math.Abs(at-s.at) * float64(size-1)
+}
+
+// spansGrid reports whether the samples lie on at least three rows and three
+// columns, the least a homography can be fitted to.
+func spansGrid(samples []lineSample, size int) bool {
+	n := float64(size - 1)
+	rows, cols := make(map[int]bool), make(map[int]bool)
+	for _, s := range samples {
+		k := int(math.Round(s.at * n))
+		if s.rows {
+			rows[k] = true
+		} else {
+			cols[k] = true
+		}
+	}
+	return len(rows) >= 3 && len(cols) >= 3
+}
+
+// lumAt returns the brightness of img at p, interpolated between pixels. Pixels
+// outside img are black.
+func lumAt(img *image.NRGBA, p Point) float64 {
+	x0, y0 := int(math.Floor(p.X)), int(math.Floor(p.Y))
+	fx, fy := p.X-float64(x0), p.Y-float64(y0)
+	at := func(x, y int) float64 {
+		if !(image.Point{x, y}).In(img.Rect) {
+			return 0
+		}
+		c := img.Pix[img.PixOffset(x, y):]
+		return (float64(c[0]) + float64(c[1]) + float64(c[2])) / 3
+	}
+	top := at(x0, y0)*(1-fx) + at(x0+1, y0)*fx
+	bottom := at(x0, y0+1)*(1-fx) + at(x0+1, y0+1)*fx
+	return top*(1-fy) + bottom*fy
+}
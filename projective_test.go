@@ -0,0 +1,62 @@
+package gobancrop
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestFitGrid(t *testing.T) {
+	img, h := angledBoard(Quadrilateral{{150, 60}, {500, 100}, {570, 430}, {80, 400}})
+	lo, hi := 20.0, 20.0+18*20
+	var want Quadrilateral
+	for i, c := range [4]Point{{lo, lo}, {hi, lo}, {hi, hi}, {lo, hi}} {
+		want[i] = h.Apply(c)
+	}
+	// Each corner off by a different amount, so the quad is no longer the board in perspective
+	off := [4]Point{{-6, -3}, {0, -6}, {7, 2}, {-5, 7}}
+	var guess Quadrilateral
+	for i := range guess {
+		guess[i] = Point{want[i].X + off[i].X, want[i].Y + off[i].Y}
+	}
+	fit, rows, cols, err := FitGrid(img, guess, 19)
+	if err != nil {
+		t.Fatalf("FitGrid: %v", err)
+	}
+	for i := range fit {
+		if dist(fit[i], want[i]) > 0.5 {
+			t.Errorf("corner %d at %v, want %v", i, fit[i], want[i])
+		}
+	}
+	// Every line was seen, on the fit
+	for k := range rows {
+		if !(rows[k] < 0.05) || !(cols[k] < 0.05) {
+			t.Errorf("line %d: residuals %.3f and %.3f", k, rows[k], cols[k])
+		}
+	}
+
+	// A coarse quad that is off makes an uneven warp, the grid should still be found where it is
+	var coarse Quadrilateral
+	for i, c := range [4]Point{{0, 0}, {400, 0}, {400, 400}, {0, 400}} {
+		p := h.Apply(c)
+		coarse[i] = Point{p.X + 1.5*off[i].X, p.Y + 1.5*off[i].Y}
+	}
+	g, err := FindActualBoard(img, coarse, 19)
+	if err != nil {
+		t.Fatalf("FindActualBoard: %v", err)
+	}
+	for i := range g.Quad {
+		if dist(g.Quad[i], want[i]) > 0.5 {
+			t.Errorf("FindActualBoard corner %d at %v, want %v", i, g.Quad[i], want[i])
+		}
+	}
+
+	// Nothing to fit to on a blank image
+	blank := image.NewNRGBA(image.Rect(0, 0, 400, 400))
+	draw.Draw(blank, blank.Bounds(), image.NewUniform(color.NRGBA{200, 160, 100, 255}), image.Point{}, draw.Src)
+	if _, _, _, err := FitGrid(blank, Quadrilateral{{50, 50}, {350, 50}, {350, 350}, {50, 350}}, 19); !errors.Is(err, ErrGridNotFound) {
+		t.Errorf("blank image: %v, want ErrGridNotFound", err)
+	}
+}
@@ -0,0 +1,79 @@
+package gobancrop
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SGFInfo holds the optional properties written along with a position.
+type SGFInfo struct {
+	ToPlay  Stone  // PL, left out when Empty
+	Source  string // SO, for instance the input filename
+	Comment string // C, for instance the detection confidence
+}
+
+// WriteSGF writes the position as an SGF (FF[4]) record, with the stones as AB
+// and AW setup properties.
+func (b *Board) WriteSGF(w io.Writer, info SGFInfo) error {
+	if b.Size < 1 || b.Size > 52 {
+		return fmt.Errorf("board size %d can not be written as SGF", b.Size)
+	}
+	if len(b.Stones) != b.Size*b.Size {
+		return errors.New("board has the wrong number of intersections")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "(;GM[1]FF[4]CA[UTF-8]AP[gobancrop]SZ[%d]", b.Size)
+	switch info.ToPlay {
+	case Black:
+		buf.WriteString("PL[B]")
+	case White:
+		buf.WriteString("PL[W]")
+	}
+	if info.Source != "" {
+		fmt.Fprintf(&buf, "SO[%s]", sgfEscape(info.Source))
+	}
+	if info.Comment != "" {
+		fmt.Fprintf(&buf, "C[%s]", sgfEscape(info.Comment))
+	}
+	for _, stone := range []Stone{Black, White} {
+		prop := "AB"
+		if stone == White {
+			prop = "AW"
+		}
+		for i, s := range b.Stones {
+			if s != stone {
+				continue
+			}
+			buf.WriteString(prop)
+			prop = ""
+			fmt.Fprintf(&buf, "[%c%c]", sgfCoord(i%b.Size), sgfCoord(i/b.Size))
+		}
+	}
+	buf.WriteString(")\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// SGF returns the position as an SGF record, see WriteSGF.
+func (b *Board) SGF(info SGFInfo) (string, error) {
+	var sb strings.Builder
+	if err := b.WriteSGF(&sb, info); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// sgfCoord returns the SGF letter for a zero-based line number, a-z then A-Z.
+func sgfCoord(i int) byte {
+	if i < 26 {
+		return byte('a' + i)
+	}
+	return byte('A' + i - 26)
+}
+
+func sgfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,122 @@
+package gobancrop
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// StageSink receives the intermediate images of the detection, by name:
+//
+//	wood-raw       pixels with the colour of wood
+//	wood           the wood mask after cleaning it up
+//	warped         the coarse board, perspective corrected to WarpSize
+//	palette        the warped board after palette reduction, if PaletteColors is set
+//	grid-pixels    the pixels of the warped board that may be part of a line
+//	stones         the pixels of the warped board that are masked out as stones
+//	profile-rows   the share of line pixels in each row, with the chosen lines in red
+//	profile-cols   the same for each column
+//	edges          the edges found by DetectEdges, when looking for the board by its lines
+//
+// Images are only built when a sink is set.
+type StageSink func(name string, img image.Image)
+
+// DirSink returns a StageSink that writes every image it receives to dir as a
+// PNG file, numbered in the order they arrive.
+func DirSink(dir string) (StageSink, error) {
+	return NewDetector().DirSink(dir)
+}
+
+// DirSink is like the package level DirSink, logging the images it could not
+// write to the logger of d. The detection goes on without them.
+func (d *Detector) DirSink(dir string) (StageSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	n := 0
+	return func(name string, img image.Image) {
+		n++
+		path := filepath.Join(dir, fmt.Sprintf("%02d-%s.png", n, name))
+		f, err := os.Create(path)
+		if err != nil {
+			d.log().Warn("DirSink: cannot write stage", "path", path, "err", err)
+			return
+		}
+		err = png.Encode(f, img)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			d.log().Warn("DirSink: cannot write stage", "path", path, "err", err)
+		}
+	}, nil
+}
+
+// stage passes the image made by build to the sink of d, if there is one.
+func (d *Detector) stage(name string, build func() image.Image) {
+	if d.Stages != nil {
+		d.Stages(name, build())
+	}
+}
+
+// boolImage renders a w×h mask as white on black.
+func boolImage(mask []bool, w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i, on := range mask {
+		if on {
+			img.Pix[i] = 255
+		}
+	}
+	return img
+}
+
+// plotProfile draws the share of line pixels along each row of p as a bar
+// chart, with rows that are mostly masked out in grey and the lines at the
+// given positions as red ticks. Columns are plotted upwards, rows to the right.
+func plotProfile(p projection, lines []float64, columns bool) *image.NRGBA {
+	const depth = 128
+	n := len(p.cnt)
+	rect := image.Rect(0, 0, depth, n)
+	if columns {
+		rect = image.Rect(0, 0, n, depth)
+	}
+	img := image.NewNRGBA(rect)
+	set := func(i, v int, c color.NRGBA) {
+		if columns {
+			img.SetNRGBA(i, depth-1-v, c)
+		} else {
+			img.SetNRGBA(v, i, c)
+		}
+	}
+	white := color.NRGBA{255, 255, 255, 255}
+	for i := 0; i < n; i++ {
+		for v := 0; v < depth; v++ {
+			set(i, v, white)
+		}
+	}
+	for _, l := range lines {
+		if i := int(math.Round(l)); i >= 0 && i < n {
+			for v := 0; v < depth; v++ {
+				set(i, v, color.NRGBA{255, 200, 200, 255})
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		c := color.NRGBA{0, 0, 0, 255}
+		if !p.informative(i) {
+			c = color.NRGBA{160, 160, 160, 255}
+		}
+		bar := 0
+		if p.valid[i] > 0 {
+			bar = p.cnt[i] * (depth - 1) / p.valid[i]
+		}
+		for v := 0; v <= bar; v++ {
+			set(i, v, c)
+		}
+	}
+	return img
+}
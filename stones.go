@@ -0,0 +1,66 @@
+package gobancrop
+
+import (
+	"image"
+	"sort"
+)
+
+// woodBrightness returns the median brightness of the pixels of img within
+// GridHueTolerance of GridHue and at least WoodMinSaturation saturated, in the
+// 0-65535 range, or 0 if there are none.
+func (d *Detector) woodBrightness(img *image.NRGBA) uint32 {
+	return medianBrightness(img, func(hue, s float64) bool {
+		return s >= d.WoodMinSaturation && hueDelta(hue, d.GridHue) <= d.GridHueTolerance
+	})
+}
+
+// medianBrightness returns the median brightness of the pixels of img whose hue
+// and saturation are kept, in the 0-65535 range, or 0 if there are none.
+func medianBrightness(img *image.NRGBA, keep func(hue, s float64) bool) uint32 {
+	b := img.Bounds()
+	var vals []uint32
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x += 2 {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			hue, s, _ := rgbToHSV(float64(r)/65535, float64(g)/65535, float64(bl)/65535)
+			if keep(hue, s) {
+				vals = append(vals, (r+g+bl)/3)
+			}
+		}
+	}
+	if len(vals) == 0 {
+		return 0
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	return vals[len(vals)/2]
+}
+
+// stoneMask marks the black and white stones in a w×h image whose origin is (0,0).
+// Black stones are much darker than the wood and white stones are bright and
+// unsaturated. An opening wider than lineWidth removes the grid lines, star
+// points and labels, so only the thick, disc-shaped blobs of stones survive.
+func stoneMask(img *image.NRGBA, w, h int, wood uint32, lineWidth int) []bool {
+	black := make([]bool, w*h)
+	white := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			avg := (r + g + b) / 3
+			_, s, v := rgbToHSV(float64(r)/65535, float64(g)/65535, float64(b)/65535)
+			black[y*w+x] = avg < wood*2/5
+			white[y*w+x] = s < 0.2 && v > 0.6 && avg > wood
+		}
+	}
+	r := lineWidth/2 + 1
+	mask := make([]bool, w*h)
+	for _, m := range [][]bool{black, white} {
+		// The opening rounds off the stones, grow them back within the stone colour
+		opened := morph(morph(m, w, h, r, false), w, h, r, true)
+		grown := morph(opened, w, h, r+1, true)
+		for i := range mask {
+			mask[i] = mask[i] || grown[i] && m[i]
+		}
+	}
+	// Grow a little to cover the anti-aliased rims
+	return morph(mask, w, h, 1, true)
+}
@@ -1,9 +1,11 @@
 package gobancrop
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"math"
+	"slices"
 	"sort"
 )
 
@@ -11,18 +13,29 @@ type Point struct{ X, Y float64 }
 
 type Quadrilateral [4]Point
 
-// shrinkQuadAligned insets an axis-aligned quad by half a grid cell on all sides, trimming margins and labels.
-func shrinkQuadAligned(q Quadrilateral) Quadrilateral {
-	minX, minY := q[0].X, q[0].Y
-	maxX, maxY := q[2].X, q[2].Y
-	cell := (maxX - minX) / 18.0
-	inset := cell * 0.5
-	return Quadrilateral{
-		{minX + inset, minY + inset},
-		{maxX - inset, minY + inset},
-		{maxX - inset, maxY - inset},
-		{minX + inset, maxY - inset},
+// sub returns a-b.
+func sub(a, b Point) Point {
+	return Point{a.X - b.X, a.Y - b.Y}
+}
+
+// dist returns the distance between a and b.
+func dist(a, b Point) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
+
+// ShrinkQuad insets a quad by half a grid cell of a size×size board on all sides,
+// trimming margins and labels.
+func ShrinkQuad(q Quadrilateral, size int) (Quadrilateral, error) {
+	if size < 2 {
+		return Quadrilateral{}, fmt.Errorf("%w: %d", ErrInvalidBoardSize, size)
 	}
+	d := 0.5 / float64(size-1)
+	return Quadrilateral{
+		interpQuadPoint(q, d, d),
+		interpQuadPoint(q, 1-d, d),
+		interpQuadPoint(q, 1-d, 1-d),
+		interpQuadPoint(q, d, 1-d),
+	}, nil
 }
 
 func cropQuad(img *image.NRGBA, q Quadrilateral) *image.NRGBA {
@@ -46,7 +59,12 @@ func cropQuad(img *image.NRGBA, q Quadrilateral) *image.NRGBA {
 	return img.SubImage(r).(*image.NRGBA)
 }
 
+// interpQuadPoint maps (u,v) in the unit square onto q with a perspective transform,
+// falling back to bilinear interpolation when q is degenerate.
 func interpQuadPoint(q Quadrilateral, u, v float64) Point {
+	if h, err := SquareToQuad(q); err == nil {
+		return h.Apply(Point{u, v})
+	}
 	return Point{X: (1-v)*((1-u)*q[0].X+u*q[1].X) + v*((1-u)*q[3].X+u*q[2].X), Y: (1-v)*((1-u)*q[0].Y+u*q[1].Y) + v*((1-u)*q[3].Y+u*q[2].Y)}
 }
 
@@ -110,134 +128,210 @@ func rgbToHSV(r, g, b float64) (h, s, v float64) {
 	return
 }
 
-func brightnessHist(img *image.NRGBA, mask func(color.Color) bool) (hist [256]int, masked, total int) {
-	b := img.Bounds()
-	for y := b.Min.Y; y < b.Max.Y; y += 2 {
-		for x := b.Min.X; x < b.Max.X; x += 2 {
-			total++
-			c := img.At(x, y)
-			if !mask(c) {
+// projection counts, for each of limit rows or columns, the line pixels and the
+// unmasked pixels along it.
+type projection struct {
+	cnt, valid []int
+	depth      int
+}
+
+func project(limit, depth int, isDark func(int, int) bool, mask func(int, int) bool) projection {
+	p := projection{make([]int, limit), make([]int, limit), depth}
+	for i := 0; i < limit; i++ {
+		for j := 0; j < depth; j++ {
+			if !mask(i, j) {
 				continue
 			}
-			masked++
-			hist[avgBrightness(c)/257]++
+			p.valid[i]++
+			if isDark(i, j) {
+				p.cnt[i]++
+			}
 		}
 	}
-	return
+	return p
 }
 
-func otsu(hist [256]int, total int) int {
-	var sumT, sumB, wB, maxV float64
-	var thresh int
-	for i, c := range hist {
-		sumT += float64(i * c)
-	}
-	for t := 0; t < 256; t++ {
-		wB += float64(hist[t])
-		if wB == 0 {
+// informative reports if enough of row i is unmasked to tell anything.
+func (p projection) informative(i int) bool {
+	return p.valid[i] >= p.depth/8
+}
+
+// scanSegments returns the runs of rows where at least frac of the unmasked pixels
+// are line pixels, leaving out runs wider than maxW.
+func (p projection) scanSegments(frac float64, maxW int) [][2]int {
+	var segs [][2]int
+	start := -1
+	for i := 0; i <= len(p.cnt); i++ {
+		// Masked out pixels count neither way, but a mostly masked line tells nothing
+		if i < len(p.cnt) && p.informative(i) && p.cnt[i] >= int(frac*float64(p.valid[i])) {
+			if start < 0 {
+				start = i
+			}
 			continue
 		}
-		wF := float64(total) - wB
-		if wF == 0 {
-			break
-		}
-		sumB += float64(t * hist[t])
-		mB := sumB / wB
-		mF := (sumT - sumB) / wF
-		v := wB * wF * (mB - mF) * (mB - mF)
-		if v > maxV {
-			maxV = v
-			thresh = t
+		if start >= 0 && i-start <= maxW {
+			segs = append(segs, [2]int{start, i - 1})
 		}
+		start = -1
 	}
-	return thresh
+	return segs
 }
 
-func estimateDarkFrac(img *image.NRGBA, thr uint32) float64 {
-	h := img.Bounds().Dy()
-	col := img.Bounds().Dx() / 2
-	var runs []int
-	run := 0
-	for y := 0; y < h; y++ {
-		r, g, b, _ := img.At(col, y).RGBA()
-		if (r+g+b)/3 < thr {
-			run++
-		} else if run > 0 {
-			runs = append(runs, run)
-			run = 0
-		}
+// latticeFit is an evenly spaced set of lines fitted to segment midpoints.
+type latticeFit struct {
+	lines     []float64
+	residuals []float64 // distance to the observed segment in units of the pitch, NaN if inferred
+	score     float64   // in [0,1]
+}
+
+// fitLattice fits size evenly spaced lines within [0, extent] to the segments
+// found by scanSegments. Candidate pitches come from pairs of nearby segments, the
+// phase is voted for by all segments, and segments more than a fifth of the pitch
+// off the lattice are ignored as outliers. Lines without a segment are inferred.
+// Fits that explain the segments equally well are told apart by how centred they are.
+func fitLattice(segs [][2]int, size, extent int) (latticeFit, bool) {
+	if len(segs) < 2 || size < 2 {
+		return latticeFit{}, false
 	}
-	if run > 0 {
-		runs = append(runs, run)
+	mids := segmentCentres(segs)
+	n := float64(size - 1)
+	// The coarse quad hugs the board, so the grid should cover most of it
+	minPitch, maxPitch := float64(extent)/2/n, float64(extent)*1.05/n
+
+	var best latticeFit
+	bestObserved, bestErr, bestOff := 0, math.Inf(1), math.Inf(1)
+	tried := make(map[int]bool)
+	for i := range mids {
+		for j := i + 1; j < len(mids) && j <= i+3; j++ {
+			for d := 1; d <= 3; d++ {
+				pitch := (mids[j] - mids[i]) / float64(d)
+				if pitch < minPitch || pitch > maxPitch || tried[int(pitch*4)] {
+					continue
+				}
+				tried[int(pitch*4)] = true
+				for _, phase := range mids {
+					for _, lines := range placeLattice(mids, pitch, phase, size, extent) {
+						f, observed, e := refineLattice(mids, lines, size)
+						// Between equally good fits, prefer the one centred in the quad
+						off := math.Abs(f.lines[0] + f.lines[size-1] - float64(extent))
+						if observed > bestObserved || observed == bestObserved &&
+							(e < bestErr-0.02 || e < bestErr+0.02 && off < bestOff) {
+							best, bestObserved, bestErr, bestOff = f, observed, e, off
+						}
+					}
+				}
+			}
+		}
 	}
-	if len(runs) == 0 {
-		return 0.02
+	if bestObserved < 2 {
+		return latticeFit{}, false
 	}
-	sort.Ints(runs)
-	return float64(runs[len(runs)/2]) / float64(h)
+	return best, true
 }
 
-func autoSetup(img *image.NRGBA) (uint32, uint32, float64) {
-	hist, m, _ := brightnessHist(img, func(color.Color) bool { return true })
-	t := otsu(hist, m)
-	f := estimateDarkFrac(img, uint32(t)*257)
-	if f < 0.01 {
-		f = 0.01
+// placeLattice lays out size lines with the given pitch through phase. It returns
+// every shift that covers the most segment midpoints while staying within extent.
+func placeLattice(mids []float64, pitch, phase float64, size, extent int) [][]float64 {
+	tol := pitch / 5
+	hit := make(map[int]bool)
+	lo, hi := math.MaxInt, math.MinInt
+	for _, m := range mids {
+		t := math.Round((m - phase) / pitch)
+		if math.Abs(m-phase-t*pitch) <= tol {
+			hit[int(t)] = true
+			lo, hi = min(lo, int(t)), max(hi, int(t))
+		}
 	}
-	return uint32(t) * 257, uint32((t+255)/2) * 257, f
-}
-
-func scanSegments(limit, depth int, thr uint32, frac float64, maxW int,
-	isDark func(int, int, uint32) bool, mask func(int, int) bool,
-) [][2]int {
-	minD := int(frac * float64(depth))
-	var raw [][]int
-	var curr []int
-	for i := 0; i < limit; i++ {
-		cnt := 0
-		for j := 0; j < depth; j++ {
-			if !mask(i, j) {
-				continue
-			}
-			if isDark(i, j, thr) {
-				cnt++
+	var starts []int
+	observed := 2
+	for t0 := hi - size + 1; t0 <= lo; t0++ {
+		start := phase + float64(t0)*pitch
+		if start < -tol || start+float64(size-1)*pitch > float64(extent)+tol {
+			continue
+		}
+		count := 0
+		for t := t0; t < t0+size; t++ {
+			if hit[t] {
+				count++
 			}
 		}
-		if cnt >= minD {
-			curr = append(curr, i)
-		} else if len(curr) > 0 {
-			raw = append(raw, curr)
-			curr = nil
+		if count > observed {
+			observed, starts = count, starts[:0]
+		}
+		if count == observed {
+			starts = append(starts, t0)
 		}
 	}
-	if len(curr) > 0 {
-		raw = append(raw, curr)
-	}
-	var segs [][2]int
-	for _, s := range raw {
-		if len(s) <= maxW {
-			segs = append(segs, [2]int{s[0], s[len(s)-1]})
+	var out [][]float64
+	for _, t0 := range starts {
+		lines := make([]float64, size)
+		for k := range lines {
+			lines[k] = phase + float64(t0+k)*pitch
 		}
+		out = append(out, lines)
 	}
-	return segs
+	return out
 }
 
-func refineLines(segs [][2]int) []float64 {
-	if len(segs) < 2 {
-		return nil
+// refineLattice matches segment midpoints to lines, refits start and pitch by least
+// squares over the matches and scores the result. It returns the fit, the number
+// of observed lines and the mean residual.
+func refineLattice(mids, lines []float64, size int) (latticeFit, int, float64) {
+	pitch := (lines[size-1] - lines[0]) / float64(size-1)
+	tol := pitch / 5
+	match := func(lines []float64) (idx []int, pos []float64) {
+		for _, m := range mids {
+			k := int(math.Round((m - lines[0]) / pitch))
+			if k >= 0 && k < size && math.Abs(m-lines[k]) <= tol {
+				idx = append(idx, k)
+				pos = append(pos, m)
+			}
+		}
+		return
 	}
-	mids := make([]float64, len(segs))
-	for i, s := range segs {
-		mids[i] = float64(s[0]+s[len(s)-1]) / 2
+	idx, pos := match(lines)
+	if len(idx) >= 2 {
+		var sk, sm, skk, skm float64
+		for i, k := range idx {
+			fk := float64(k)
+			sk, sm, skk, skm = sk+fk, sm+pos[i], skk+fk*fk, skm+fk*pos[i]
+		}
+		cnt := float64(len(idx))
+		if den := cnt*skk - sk*sk; den != 0 {
+			p := (cnt*skm - sk*sm) / den
+			start := (sm - p*sk) / cnt
+			refined := make([]float64, size)
+			for k := range refined {
+				refined[k] = start + float64(k)*p
+			}
+			pitch, tol, lines = p, p/5, refined
+			idx, pos = match(lines)
+		}
 	}
-	sort.Float64s(mids)
-	start, end := mids[0], mids[len(mids)-1]
-	step := (end - start) / 18
-	lines := make([]float64, 19)
-	for i := range lines {
-		lines[i] = start + float64(i)*step
+
+	f := latticeFit{lines: lines, residuals: make([]float64, size)}
+	for k := range f.residuals {
+		f.residuals[k] = math.NaN()
+	}
+	for i, k := range idx {
+		r := math.Abs(pos[i]-lines[k]) / pitch
+		if math.IsNaN(f.residuals[k]) || r < f.residuals[k] {
+			f.residuals[k] = r
+		}
+	}
+	observed, sum := 0, 0.0
+	for _, r := range f.residuals {
+		if !math.IsNaN(r) {
+			observed++
+			sum += r
+		}
+	}
+	if observed == 0 {
+		return f, 0, math.Inf(1)
 	}
-	return lines
+	mean := sum / float64(observed)
+	f.score = float64(observed) / float64(size) * float64(len(idx)) / float64(len(mids)) * (1 - mean*pitch/tol/2)
+	return f, observed, mean
 }
 
 func hueDelta(h1, h2 float64) float64 {
@@ -248,36 +342,192 @@ func hueDelta(h1, h2 float64) float64 {
 	return d
 }
 
-func findLines(img *image.NRGBA, w, h int, thr uint32, _ float64) (ys, xs []float64) {
-	woodHue := 35.0
-	mask := func(_, _ int) bool { return true }
+// lineCandidate is the best lattice found for one board size.
+type lineCandidate struct {
+	size       int
+	ys, xs     []float64
+	yRes, xRes []float64
+	score      float64
+	segs       lineSegments // of the sweep the lattice was fitted to
+
+	// Parts of the Confidence, measured on the warped board but for hoshi, which
+	// is measured in the image, in [-1, 1] as from hoshiMatch
+	lattice, hoshi, contrast, wood float64
+}
+
+// warpMasks are the warped board before palette reduction and the masks of it
+// that findLines measures the candidates on.
+type warpMasks struct {
+	raw          *image.NRGBA
+	stones, grid []bool
+	wooden       bool // some of raw has the colour of wood
+}
+
+// measure sets the contrast and wood of c, which depend on where its lines are.
+// The wood is NaN for a board without the colour of wood, such as a grey one.
+func (d *Detector) measure(c *lineCandidate, m warpMasks) {
+	c.contrast = lineContrast(m.raw, m.stones, c.ys, c.xs)
+	c.wood = math.NaN()
+	if m.wooden {
+		c.wood = d.woodCoverage(m.raw, m.stones, m.grid, c.ys, c.xs)
+	}
+}
+
+// findLines searches for a lattice of each of the given sizes and returns the
+// candidates that were found, best first, and the masks they were measured on.
+// raw is img before palette reduction, for the stones and the colours of the wood.
+func (d *Detector) findLines(img, raw *image.NRGBA, w, h int, sizes []int) ([]lineCandidate, lineSegments, warpMasks) {
+	woodHue := d.GridHue
 
+	// Lines are darker than the wood around them, by how much depends on the board.
+	// A board that is not wooden, like a grey one found by FindGobanLines, has
+	// no hue to go by and is compared with its overall brightness.
+	darkThr := uint32(20000)
+	wood := d.woodBrightness(img)
+	if wood > 0 {
+		darkThr = wood * 3 / 4
+	} else if all := medianBrightness(img, func(_, _ float64) bool { return true }); all > 0 {
+		darkThr = all * 3 / 4
+	}
 	isGridPixel := func(x, y int) bool {
 		r, g, b, _ := img.At(x, y).RGBA()
 		avg := (r + g + b) / 3
 		hue, _, _ := rgbToHSV(float64(r)/65535, float64(g)/65535, float64(b)/65535)
-		return hueDelta(hue, woodHue) > 25 || avg < 20000
+		return wood > 0 && hueDelta(hue, woodHue) > d.GridHueTolerance || avg < darkThr
 	}
 
-	fracs := []float64{0.03, 0.025, 0.02, 0.015, 0.01, 0.0075}
-	widths := []int{8, 7, 6, 5, 4, 3}
+	// Rows and columns full of stones would otherwise look like lines. Palette
+	// reduction can merge stones with the wood, so they are found in raw.
+	stones := make([]bool, w*h)
+	rawWood := d.woodBrightness(raw)
+	if rawWood > 0 {
+		stones = stoneMask(raw, w, h, rawWood, d.MaxLineWidth)
+	}
+	maskH := func(y, x int) bool { return !stones[y*w+x] }
+	maskV := func(x, y int) bool { return !stones[y*w+x] }
 
-	for _, frac := range fracs {
-		for _, width := range widths {
-			isDarkH := func(y, x int, _ uint32) bool { return isGridPixel(x, y) }
-			isDarkV := func(x, y int, _ uint32) bool { return isGridPixel(x, y) }
+	// Classify every pixel once, the sweep below only looks them up
+	grid := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			grid[y*w+x] = isGridPixel(x, y)
+		}
+	}
+	d.stage("grid-pixels", func() image.Image { return boolImage(grid, w, h) })
+	d.stage("stones", func() image.Image { return boolImage(stones, w, h) })
+	rows := project(h, w, func(y, x int) bool { return grid[y*w+x] }, maskH)
+	cols := project(w, h, func(x, y int) bool { return grid[y*w+x] }, maskV)
 
-			hs := scanSegments(h, w, thr, frac, width, isDarkH, mask)
-			vs := scanSegments(w, h, thr, frac, width, isDarkV, mask)
+	best := make([][]lineCandidate, len(sizes))
+	var near lineSegments
+	nearMiss := math.MaxInt
+	for _, frac := range d.LineFracs {
+		for _, width := range d.LineWidths {
+			hs := rows.scanSegments(frac, width)
+			vs := cols.scanSegments(frac, width)
+			for _, size := range sizes {
+				if miss := abs(len(hs)-size) + abs(len(vs)-size); miss < nearMiss {
+					near, nearMiss = lineSegments{hs, vs}, miss
+				}
+			}
 
-			ysCand := refineLines(hs)
-			xsCand := refineLines(vs)
+			for i, size := range sizes {
+				fy, okY := fitLattice(hs, size, h)
+				fx, okX := fitLattice(vs, size, w)
+				if !okY || !okX {
+					continue
+				}
+				c := lineCandidate{size: size, ys: fy.lines, xs: fx.lines, yRes: fy.residuals, xRes: fx.residuals, score: fy.score * fx.score, segs: lineSegments{hs, vs}}
+				best[i] = keepCandidate(best[i], c)
+			}
+		}
+	}
 
-			if len(ysCand) == 19 && len(xsCand) == 19 {
-				return ysCand, xsCand
+	masks := warpMasks{raw, stones, grid, rawWood > 0}
+	var cands []lineCandidate
+	for _, c := range slices.Concat(best...) {
+		c.lattice = math.Sqrt(c.score)
+		d.measure(&c, masks)
+		d.log().Debug("findLines: candidate", "size", c.size, "score", c.score,
+			"contrast", c.contrast, "wood", c.wood, "woodBrightness", wood, "darkThreshold", darkThr)
+		cands = append(cands, c)
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].score > cands[j].score })
+	var ys, xs []float64
+	if len(cands) > 0 {
+		ys, xs = cands[0].ys, cands[0].xs
+	}
+	d.stage("profile-rows", func() image.Image { return plotProfile(rows, ys, false) })
+	d.stage("profile-cols", func() image.Image { return plotProfile(cols, xs, true) })
+	return cands, near, masks
+}
+
+// candidatesPerSize is how many distinct lattices findLines keeps of each board size.
+const candidatesPerSize = 4
+
+// keepCandidate adds c to the candidates of its size, best first, unless a better
+// candidate with the same outer lines is already there.
+func keepCandidate(cands []lineCandidate, c lineCandidate) []lineCandidate {
+	for i, o := range cands {
+		if sameLattice(o, c) {
+			if c.score <= o.score {
+				return cands
 			}
+			cands = slices.Delete(cands, i, i+1)
+			break
 		}
 	}
+	i := sort.Search(len(cands), func(i int) bool { return cands[i].score < c.score })
+	cands = slices.Insert(cands, i, c)
+	if len(cands) > candidatesPerSize {
+		cands = cands[:candidatesPerSize]
+	}
+	return cands
+}
+
+// sameLattice reports if the outer lines of a and b are within a third of a cell of each other.
+func sameLattice(a, b lineCandidate) bool {
+	if a.size != b.size {
+		return false
+	}
+	last := a.size - 1
+	tol := (a.xs[last] - a.xs[0] + a.ys[last] - a.ys[0]) / float64(last) / 2 / 3
+	for _, p := range [][2]float64{{a.ys[0], b.ys[0]}, {a.ys[last], b.ys[last]}, {a.xs[0], b.xs[0]}, {a.xs[last], b.xs[last]}} {
+		if math.Abs(p[0]-p[1]) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+// lineSegments are the horizontal and vertical runs of line rows and columns
+// found by one sweep of findLines.
+type lineSegments struct {
+	hs, vs [][2]int
+}
 
-	return nil, nil
+// segmentCentres returns the middle of each segment, in order.
+func segmentCentres(segs [][2]int) []float64 {
+	mids := make([]float64, len(segs))
+	for i, s := range segs {
+		mids[i] = float64(s[0]+s[1]) / 2
+	}
+	sort.Float64s(mids)
+	return mids
+}
+
+// segmentMids returns the middle of each segment as a fraction of extent.
+func segmentMids(segs [][2]int, extent int) []float64 {
+	mids := make([]float64, len(segs))
+	for i, s := range segs {
+		mids[i] = float64(s[0]+s[1]) / 2 / float64(extent-1)
+	}
+	return mids
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
 }